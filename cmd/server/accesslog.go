@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// accessLog logs one line per request. Successful responses are logged
+// with probability sampleRate to keep volume down at high QPS; 4xx and 5xx
+// responses are always logged so errors stay visible. A non-zero seed makes
+// the sampling decisions reproducible.
+func accessLog(sampleRate float64, seed uint64, handler http.Handler) http.Handler {
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	var mu sync.Mutex
+	rng := rand.New(rand.NewPCG(seed, seed))
+	sampled := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return rng.Float64() < sampleRate
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		handler.ServeHTTP(recorder, r)
+
+		if recorder.status < http.StatusBadRequest && !sampled() {
+			return
+		}
+		slog.Info("http request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration", time.Since(start),
+			"remote_addr", r.RemoteAddr,
+			"request_id", requestIDFromContext(r.Context()),
+		)
+	})
+}
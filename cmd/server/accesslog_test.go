@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessLogSampling(t *testing.T) {
+	const requests = 200
+	tests := []struct {
+		name       string
+		sampleRate float64
+		status     int
+		wantLogged func(n int) bool
+	}{
+		{name: "successes at rate 0", sampleRate: 0, status: http.StatusOK, wantLogged: func(n int) bool { return n == 0 }},
+		{name: "successes at rate 1", sampleRate: 1, status: http.StatusOK, wantLogged: func(n int) bool { return n == requests }},
+		{name: "successes at rate 0.5", sampleRate: 0.5, status: http.StatusOK, wantLogged: func(n int) bool { return n > 0 && n < requests }},
+		{name: "redirects are sampled", sampleRate: 0, status: http.StatusPermanentRedirect, wantLogged: func(n int) bool { return n == 0 }},
+		{name: "client errors always logged", sampleRate: 0, status: http.StatusNotFound, wantLogged: func(n int) bool { return n == requests }},
+		{name: "server errors always logged", sampleRate: 0, status: http.StatusInternalServerError, wantLogged: func(n int) bool { return n == requests }},
+		{name: "errors at rate 0.5", sampleRate: 0.5, status: http.StatusServiceUnavailable, wantLogged: func(n int) bool { return n == requests }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			handler := accessLog(tt.sampleRate, 0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			for range requests {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello", nil))
+			}
+
+			records := logRecords(t, logs)
+			if !tt.wantLogged(len(records)) {
+				t.Fatalf("logged %d of %d requests", len(records), requests)
+			}
+			for _, record := range records {
+				if got := record["status"]; got != float64(tt.status) {
+					t.Fatalf("logged status %v, want %d", got, tt.status)
+				}
+			}
+		})
+	}
+}
+
+func TestAccessLogSeededSampling(t *testing.T) {
+	const (
+		requests   = 10000
+		sampleRate = 0.25
+	)
+	run := func(seed uint64) int {
+		logs := captureLogs(t)
+		handler := accessLog(sampleRate, seed, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		for range requests {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello", nil))
+		}
+		return len(logRecords(t, logs))
+	}
+
+	logged := run(42)
+	// With a fixed seed this is deterministic; the bound documents how
+	// close to the configured rate it lands.
+	if got := float64(logged) / requests; math.Abs(got-sampleRate) > 0.02 {
+		t.Fatalf("logged fraction = %v, want within 0.02 of %v", got, sampleRate)
+	}
+	if again := run(42); again != logged {
+		t.Errorf("same seed logged %d then %d requests, want identical runs", logged, again)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+)
+
+// cappedBuffer keeps the first max bytes written to it and remembers
+// whether anything was dropped. Writes never fail.
+type cappedBuffer struct {
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - len(b.buf); room < len(p) {
+		b.buf = append(b.buf, p[:max(room, 0)]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
+
+// sensitiveFieldNames are the field names whose values never reach the
+// logs.
+const sensitiveFieldNames = `(?:password|passwd|secret|token|access_token|refresh_token|api_?key|authorization|credit_?card|ssn)`
+
+var (
+	sensitiveJSONFields = regexp.MustCompile(`(?i)("` + sensitiveFieldNames + `"\s*:\s*)"(?:[^"\\]|\\.)*"?`)
+	sensitiveFormFields = regexp.MustCompile(`(?i)((?:^|&)` + sensitiveFieldNames + `=)[^&]*`)
+)
+
+// redactBody masks the values of sensitive JSON members and form fields in
+// a captured, possibly truncated, body.
+func redactBody(body []byte) string {
+	s := sensitiveJSONFields.ReplaceAllString(string(body), `$1"[REDACTED]"`)
+	return sensitiveFormFields.ReplaceAllString(s, `$1[REDACTED]`)
+}
+
+// logBodies logs request and response bodies, up to maxBytes each, for
+// troubleshooting. The request body is tee-read so the handler still sees
+// all of it. Sensitive fields are redacted, but bodies may still contain
+// personal data: keep this off unless actively debugging.
+func logBodies(maxBytes int, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody := &cappedBuffer{max: maxBytes}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, reqBody), r.Body}
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK, body: &cappedBuffer{max: maxBytes}}
+		handler.ServeHTTP(recorder, r)
+
+		slog.Info("http bodies",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"request_body", redactBody(reqBody.buf),
+			"request_body_truncated", reqBody.truncated,
+			"response_body", redactBody(recorder.body.buf),
+			"response_body_truncated", recorder.body.truncated,
+		)
+	})
+}
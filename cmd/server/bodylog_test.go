@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogBodies(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		maxBytes      int
+		wantLogged    string
+		wantTruncated bool
+	}{
+		{
+			name:       "small body",
+			body:       `{"name":"Ada"}`,
+			maxBytes:   64,
+			wantLogged: `{"name":"Ada"}`,
+		},
+		{
+			name:          "body over the cap",
+			body:          strings.Repeat("x", 100),
+			maxBytes:      10,
+			wantLogged:    strings.Repeat("x", 10),
+			wantTruncated: true,
+		},
+		{
+			name:       "sensitive JSON field",
+			body:       `{"name":"Ada","password":"hunter2"}`,
+			maxBytes:   64,
+			wantLogged: `{"name":"Ada","password":"[REDACTED]"}`,
+		},
+		{
+			name:       "sensitive form field",
+			body:       `name=Ada&api_key=abc123&x=1`,
+			maxBytes:   64,
+			wantLogged: `name=Ada&api_key=[REDACTED]&x=1`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			var received string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Errorf("read body: %v", err)
+				}
+				received = string(b)
+				_, _ = io.WriteString(w, received)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			logBodies(tt.maxBytes, handler).ServeHTTP(rec, req)
+
+			if received != tt.body {
+				t.Errorf("handler received %q, want the full body %q", received, tt.body)
+			}
+			if rec.Body.String() != tt.body {
+				t.Errorf("client received %q, want the full body %q", rec.Body, tt.body)
+			}
+			records := logRecords(t, logs)
+			if len(records) != 1 {
+				t.Fatalf("got %d log records, want 1", len(records))
+			}
+			record := records[0]
+			for _, field := range []string{"request_body", "response_body"} {
+				if record[field] != tt.wantLogged {
+					t.Errorf("%s = %q, want %q", field, record[field], tt.wantLogged)
+				}
+				if record[field+"_truncated"] != tt.wantTruncated {
+					t.Errorf("%s_truncated = %v, want %v", field, record[field+"_truncated"], tt.wantTruncated)
+				}
+			}
+		})
+	}
+}
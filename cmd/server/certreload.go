@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// certReloader serves the TLS certificate from an atomically swapped cache
+// so rotated certificates are picked up without a restart.
+type certReloader struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+	// modTime is the newest modification time across both files at the
+	// last reload attempt, used by watch to skip polls when nothing changed.
+	modTime time.Time
+	// reloads and lastReload record the reloads done by watch; nil skips
+	// recording, as in -validate.
+	reloads    *prometheus.CounterVec
+	lastReload prometheus.Gauge
+}
+
+// instrument registers the config_reload metrics for watch's reloads. The
+// timestamp starts at the initial load.
+func (cr *certReloader) instrument(registry prometheus.Registerer, namespace string) {
+	cr.reloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "config_reload_total",
+			Help:      "Total number of TLS key pair reloads triggered by SIGHUP or file changes, by result.",
+		},
+		[]string{"result"},
+	)
+	cr.reloads.WithLabelValues("success")
+	cr.reloads.WithLabelValues("failure")
+	cr.lastReload = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "config_last_reload_timestamp_seconds",
+			Help:      "Unix time of the last successful TLS key pair load.",
+		},
+	)
+	cr.lastReload.SetToCurrentTime()
+	registry.MustRegister(cr.reloads, cr.lastReload)
+}
+
+// newCertReloader loads the initial key pair; a bad certificate at startup
+// is fatal rather than something to fall back from.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// reload loads and validates the key pair from disk and swaps it in. On
+// failure the previous certificate stays in use.
+func (cr *certReloader) reload() error {
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return err
+	}
+	// Remember the attempt even if it fails, so a broken file is not
+	// retried on every poll; the next write to either file retries it.
+	cr.modTime = modTime
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parse certificate: %w", err)
+	}
+	if now := time.Now(); now.After(leaf.NotAfter) || now.Before(leaf.NotBefore) {
+		return fmt.Errorf("certificate is only valid from %s to %s", leaf.NotBefore, leaf.NotAfter)
+	}
+	cert.Leaf = leaf
+	cr.cert.Store(&cert)
+	log.Printf("loaded TLS certificate %s, expires %s", leaf.Subject, leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func (cr *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// getCertificate implements tls.Config.GetCertificate.
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.cert.Load(), nil
+}
+
+// watch reloads the key pair on every trigger and, when interval is
+// positive, whenever the files' modification time changes. It must run in a
+// single goroutine, which owns modTime.
+func (cr *certReloader) watch(ctx context.Context, trigger <-chan os.Signal, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-trigger:
+			log.Println("received SIGHUP, reloading TLS certificate")
+		case <-tick:
+			modTime, err := cr.latestModTime()
+			if err != nil || !modTime.After(cr.modTime) {
+				continue
+			}
+			log.Println("TLS certificate files changed, reloading")
+		}
+		err := cr.reload()
+		if err != nil {
+			log.Printf("TLS certificate reload failed, keeping the current certificate: %v", err)
+		}
+		cr.recordReload(err)
+	}
+}
+
+func (cr *certReloader) recordReload(err error) {
+	if cr.reloads == nil {
+		return
+	}
+	if err != nil {
+		cr.reloads.WithLabelValues("failure").Inc()
+		return
+	}
+	cr.reloads.WithLabelValues("success").Inc()
+	cr.lastReload.SetToCurrentTime()
+}
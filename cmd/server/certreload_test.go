@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// writeTestKeyPair writes a self-signed certificate for cn, valid between
+// notBefore and notAfter, and its key into dir.
+func writeTestKeyPair(t *testing.T, dir, cn string, notBefore, notAfter time.Time) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestCertReloaderSIGHUPMetrics(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		rotate      func(t *testing.T, dir string)
+		wantResult  string
+		wantSubject string
+	}{
+		{
+			name: "rotated certificate",
+			rotate: func(t *testing.T, dir string) {
+				writeTestKeyPair(t, dir, "rotated", now.Add(-time.Hour), now.Add(time.Hour))
+			},
+			wantResult:  "success",
+			wantSubject: "rotated",
+		},
+		{
+			name: "expired certificate",
+			rotate: func(t *testing.T, dir string) {
+				writeTestKeyPair(t, dir, "expired", now.Add(-2*time.Hour), now.Add(-time.Hour))
+			},
+			wantResult:  "failure",
+			wantSubject: "initial",
+		},
+		{
+			name: "unreadable key pair",
+			rotate: func(t *testing.T, dir string) {
+				if err := os.WriteFile(filepath.Join(dir, "key.pem"), []byte("garbage"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantResult:  "failure",
+			wantSubject: "initial",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLogs(t)
+			dir := t.TempDir()
+			certFile, keyFile := writeTestKeyPair(t, dir, "initial", now.Add(-time.Hour), now.Add(time.Hour))
+			cr, err := newCertReloader(certFile, keyFile)
+			if err != nil {
+				t.Fatalf("newCertReloader: %v", err)
+			}
+			cr.instrument(prometheus.NewRegistry(), "")
+			// Backdate the initial load so a successful reload is visible.
+			cr.lastReload.Set(0)
+
+			tt.rotate(t, dir)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			trigger := make(chan os.Signal, 1)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				cr.watch(ctx, trigger, 0)
+			}()
+			trigger <- syscall.SIGHUP
+
+			deadline := time.Now().Add(5 * time.Second)
+			for testutil.ToFloat64(cr.reloads.WithLabelValues(tt.wantResult)) == 0 {
+				if time.Now().After(deadline) {
+					t.Fatalf("config_reload_total{result=%q} never incremented", tt.wantResult)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			cancel()
+			<-done
+
+			for _, result := range []string{"success", "failure"} {
+				want := 0.0
+				if result == tt.wantResult {
+					want = 1
+				}
+				if got := testutil.ToFloat64(cr.reloads.WithLabelValues(result)); got != want {
+					t.Errorf("config_reload_total{result=%q} = %v, want %v", result, got, want)
+				}
+			}
+			lastReload := testutil.ToFloat64(cr.lastReload)
+			if tt.wantResult == "success" && lastReload < float64(now.Unix()) {
+				t.Errorf("config_last_reload_timestamp_seconds = %v, want it updated", lastReload)
+			}
+			if tt.wantResult == "failure" && lastReload != 0 {
+				t.Errorf("config_last_reload_timestamp_seconds = %v, want it unchanged after a failure", lastReload)
+			}
+			if got := cr.cert.Load().Leaf.Subject.CommonName; got != tt.wantSubject {
+				t.Errorf("serving certificate %q, want %q", got, tt.wantSubject)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// injectLatency delays each request by base plus a random extra of up to
+// jitter before calling handler. A request whose context is cancelled
+// while waiting returns immediately without a response. This is a chaos
+// testing knob and must stay off in production.
+func injectLatency(base, jitter time.Duration, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delay := base
+		if jitter > 0 {
+			delay += rand.N(jitter + 1)
+		}
+		trace.SpanFromContext(r.Context()).SetAttributes(
+			attribute.Int64("chaos.injected_latency_ms", delay.Milliseconds()),
+		)
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timer.C:
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// faultInjector fails a fraction of requests with a 500 so resilience can
+// be tested. Like injectLatency it must stay off in production.
+type faultInjector struct {
+	rate     float64
+	injected prometheus.Counter
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// newFaultInjector fails requests with probability rate. A non-zero seed
+// makes the sequence of injected failures reproducible.
+func newFaultInjector(rate float64, seed uint64, injected prometheus.Counter) *faultInjector {
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return &faultInjector{
+		rate:     rate,
+		injected: injected,
+		rng:      rand.New(rand.NewPCG(seed, seed)),
+	}
+}
+
+func (f *faultInjector) roll() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rng.Float64() < f.rate
+}
+
+func (f *faultInjector) wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !f.roll() {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		f.injected.Inc()
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.Bool("chaos.injected_error", true))
+		w.Header().Set("X-Chaos-Injected", "true")
+		writeError(w, http.StatusInternalServerError, "injected_fault", "chaos testing: this error was injected deliberately")
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+// CoalescingGreeter collapses concurrent identical greetings into a single
+// call to the wrapped Greeter, so a burst of requests for a hot name costs
+// one backend lookup.
+type CoalescingGreeter struct {
+	next  Greeter
+	group singleflight.Group
+	// shared counts callers whose result came from a lookup shared with
+	// concurrent identical callers, including the one that started it.
+	shared prometheus.Counter
+}
+
+func (g *CoalescingGreeter) Greet(ctx context.Context, name, lang string) (string, error) {
+	// The user ID is part of the key because DBGreeter personalizes on it.
+	userID, _ := userIDFromContext(ctx)
+	key := userID + "\x00" + name + "\x00" + lang
+
+	// The shared call must not be cut short when the caller that happened
+	// to start it goes away; the wrapped greeter bounds its own work.
+	callCtx := context.WithoutCancel(ctx)
+	ch := g.group.DoChan(key, func() (any, error) {
+		return g.next.Greet(callCtx, name, lang)
+	})
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case res := <-ch:
+		if res.Shared {
+			g.shared.Inc()
+		}
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	}
+}
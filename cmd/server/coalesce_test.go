@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// blockingGreeter counts its calls and holds each one until release is
+// closed.
+type blockingGreeter struct {
+	calls   atomic.Int64
+	release chan struct{}
+}
+
+func (g *blockingGreeter) Greet(_ context.Context, name, _ string) (string, error) {
+	g.calls.Add(1)
+	<-g.release
+	return "Hello " + name, nil
+}
+
+func TestCoalescingGreeter(t *testing.T) {
+	const callers = 10
+	tests := []struct {
+		name      string
+		key       func(i int) (userID, name string)
+		wantCalls int64
+	}{
+		{
+			name:      "same name",
+			key:       func(int) (string, string) { return "", "World" },
+			wantCalls: 1,
+		},
+		{
+			name:      "same name and user",
+			key:       func(int) (string, string) { return "u1", "World" },
+			wantCalls: 1,
+		},
+		{
+			name:      "distinct users",
+			key:       func(i int) (string, string) { return string(rune('a' + i)), "World" },
+			wantCalls: callers,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &blockingGreeter{release: make(chan struct{})}
+			shared := prometheus.NewCounter(prometheus.CounterOpts{Name: "singleflight_shared_total"})
+			g := &CoalescingGreeter{next: backend, shared: shared}
+
+			var wg sync.WaitGroup
+			for i := range callers {
+				userID, name := tt.key(i)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					got, err := g.Greet(withUserID(context.Background(), userID), name, "")
+					if err != nil || got != "Hello "+name {
+						t.Errorf("Greet = %q, %v", got, err)
+					}
+				}()
+			}
+			// Give every caller time to join the in-flight lookup before
+			// it completes.
+			time.Sleep(50 * time.Millisecond)
+			close(backend.release)
+			wg.Wait()
+
+			if got := backend.calls.Load(); got != tt.wantCalls {
+				t.Errorf("backend called %d times, want %d", got, tt.wantCalls)
+			}
+			wantShared := 0.0
+			if tt.wantCalls == 1 {
+				wantShared = callers
+			}
+			if got := testutil.ToFloat64(shared); got != wantShared {
+				t.Errorf("singleflight_shared_total = %v, want %v", got, wantShared)
+			}
+		})
+	}
+}
+
+func TestCoalescingGreeterCallerCancelled(t *testing.T) {
+	backend := &blockingGreeter{release: make(chan struct{})}
+	defer close(backend.release)
+	g := &CoalescingGreeter{next: backend, shared: prometheus.NewCounter(prometheus.CounterOpts{Name: "singleflight_shared_total"})}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := g.Greet(ctx, "World", ""); err != context.DeadlineExceeded {
+		t.Fatalf("Greet error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
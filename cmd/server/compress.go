@@ -0,0 +1,221 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// supportedEncodings lists the response encodings in server preference
+// order, which breaks ties between equal q-values.
+var supportedEncodings = []string{"br", "gzip", "identity"}
+
+// compressionLevelRange is the valid -compression-level range for an
+// encoding and the balanced level used when none is given.
+type compressionLevelRange struct {
+	min, max, balanced int
+}
+
+// compressionLevels bound -compression-level per encoding. Brotli's own
+// default of 6 is tuned for static assets; 4 is the usual choice for
+// compressing on the fly.
+var compressionLevels = map[string]compressionLevelRange{
+	"br":   {min: brotli.BestSpeed, max: brotli.BestCompression, balanced: 4},
+	"gzip": {min: gzip.BestSpeed, max: gzip.BestCompression, balanced: 6},
+}
+
+// parseCompressionLevels parses "encoding=level,..." into a level for every
+// supported encoding, using the balanced level for any not listed.
+func parseCompressionLevels(spec string) (map[string]int, error) {
+	levels := make(map[string]int, len(compressionLevels))
+	for encoding, r := range compressionLevels {
+		levels[encoding] = r.balanced
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		encoding, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not encoding=level", pair)
+		}
+		r, ok := compressionLevels[encoding]
+		if !ok {
+			return nil, fmt.Errorf("unknown encoding %q: must be br or gzip", encoding)
+		}
+		level, err := strconv.Atoi(value)
+		if err != nil || level < r.min || level > r.max {
+			return nil, fmt.Errorf("%s level %q must be between %d and %d", encoding, value, r.min, r.max)
+		}
+		levels[encoding] = level
+	}
+	return levels, nil
+}
+
+// encoder is a compressor that can be reset onto a new response and reused.
+// Both gzip.Writer and brotli.Writer qualify.
+type encoder interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+// newEncoderPools returns a pool of encoders per encoding at the given
+// levels. Compressor state is large, so reusing it across responses saves
+// most of the allocation cost of compressing small bodies.
+func newEncoderPools(levels map[string]int) map[string]*sync.Pool {
+	return map[string]*sync.Pool{
+		"br": {New: func() any {
+			return brotli.NewWriterLevel(nil, levels["br"])
+		}},
+		"gzip": {New: func() any {
+			// The level was validated by parseCompressionLevels.
+			zw, _ := gzip.NewWriterLevel(nil, levels["gzip"])
+			return zw
+		}},
+	}
+}
+
+// negotiateEncoding picks the response encoding from the Accept-Encoding
+// header, preferring the highest q-value. It returns "" when no supported
+// encoding is acceptable, i.e. identity was refused and neither br nor
+// gzip was offered.
+func negotiateEncoding(r *http.Request) string {
+	header := r.Header.Values("Accept-Encoding")
+	if len(header) == 0 {
+		return "identity"
+	}
+
+	qualities := make(map[string]float64)
+	for _, h := range header {
+		for _, part := range strings.Split(h, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+			q := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				parsed, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					continue
+				}
+				q = parsed
+			}
+			qualities[coding] = q
+		}
+	}
+
+	quality := func(coding string) float64 {
+		if q, ok := qualities[coding]; ok {
+			return q
+		}
+		if q, ok := qualities["*"]; ok {
+			return q
+		}
+		if coding == "identity" {
+			// identity is acceptable unless explicitly refused.
+			return 0.001
+		}
+		return 0
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range supportedEncodings {
+		if q := quality(coding); q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressHandler compresses responses with the encoding negotiated from
+// Accept-Encoding and answers 406 when the client refuses every encoding
+// the server can produce.
+func compressHandler(pools map[string]*sync.Pool, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r)
+		switch {
+		case encoding == "":
+			writeError(w, http.StatusNotAcceptable, "not_acceptable", "no acceptable content encoding; supported: br, gzip, identity")
+			return
+		case encoding == "identity" || r.Method == http.MethodHead:
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding, pool: pools[encoding]}
+		defer cw.close()
+		handler.ServeHTTP(cw, r)
+	})
+}
+
+// compressResponseWriter compresses the body once the handler commits to a
+// response that can carry one.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	pool        *sync.Pool
+	encoder     encoder
+	wroteHeader bool
+}
+
+func (c *compressResponseWriter) WriteHeader(code int) {
+	if c.wroteHeader {
+		return
+	}
+	if code < http.StatusOK {
+		c.ResponseWriter.WriteHeader(code)
+		return
+	}
+	c.wroteHeader = true
+
+	h := c.Header()
+	bodiless := code == http.StatusNoContent || code == http.StatusNotModified
+	if !bodiless && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", c.encoding)
+		h.Del("Content-Length")
+		c.encoder = c.pool.Get().(encoder)
+		c.encoder.Reset(c.ResponseWriter)
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *compressResponseWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		if c.Header().Get("Content-Type") == "" {
+			// Sniff before compressing, or net/http would sniff the
+			// compressed bytes.
+			c.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.encoder == nil {
+		return c.ResponseWriter.Write(b)
+	}
+	return c.encoder.Write(b)
+}
+
+func (c *compressResponseWriter) Flush() {
+	if f, ok := c.encoder.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *compressResponseWriter) close() {
+	if c.encoder != nil {
+		_ = c.encoder.Close()
+		c.pool.Put(c.encoder)
+		c.encoder = nil
+	}
+}
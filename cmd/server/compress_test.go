@@ -0,0 +1,162 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestParseCompressionLevels(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]int
+		wantErr bool
+	}{
+		{spec: "", want: map[string]int{"br": 4, "gzip": 6}},
+		{spec: "br=11", want: map[string]int{"br": 11, "gzip": 6}},
+		{spec: "gzip=1, br=0", want: map[string]int{"br": 0, "gzip": 1}},
+		{spec: "br=12", wantErr: true},
+		{spec: "gzip=0", wantErr: true},
+		{spec: "gzip=10", wantErr: true},
+		{spec: "zstd=3", wantErr: true},
+		{spec: "gzip", wantErr: true},
+		{spec: "gzip=fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseCompressionLevels(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseCompressionLevels(%q) = %v, want an error", tt.spec, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCompressionLevels(%q): %v", tt.spec, err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("parseCompressionLevels(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+// testBody is a JSON body large and varied enough for the compression level
+// to matter.
+var testBody = func() string {
+	var b strings.Builder
+	languages := []string{"de", "en", "es", "fr", "ja", "pt"}
+	b.WriteString(`{"greetings":[`)
+	for i := range 200 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"message":"Hello user-%d","language":%q,"request_id":"%08x"}`, i*7919%1000, languages[i%len(languages)], uint32(i)*2654435761)
+	}
+	b.WriteString(`]}`)
+	return b.String()
+}()
+
+func bodyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = io.WriteString(w, testBody)
+}
+
+func TestCompressHandler(t *testing.T) {
+	levels, _ := parseCompressionLevels("")
+	handler := compressHandler(newEncoderPools(levels), http.HandlerFunc(bodyHandler))
+	tests := []struct {
+		acceptEncoding string
+		wantStatus     int
+		wantEncoding   string
+	}{
+		{acceptEncoding: "", wantStatus: http.StatusOK},
+		{acceptEncoding: "gzip", wantStatus: http.StatusOK, wantEncoding: "gzip"},
+		{acceptEncoding: "gzip, br", wantStatus: http.StatusOK, wantEncoding: "br"},
+		{acceptEncoding: "br;q=0.5, gzip", wantStatus: http.StatusOK, wantEncoding: "gzip"},
+		{acceptEncoding: "identity", wantStatus: http.StatusOK},
+		{acceptEncoding: "zstd", wantStatus: http.StatusOK},
+		{acceptEncoding: "identity;q=0", wantStatus: http.StatusNotAcceptable},
+		{acceptEncoding: "*;q=0", wantStatus: http.StatusNotAcceptable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body io.Reader = rec.Body
+			switch tt.wantEncoding {
+			case "gzip":
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("gzip.NewReader: %v", err)
+				}
+				body = zr
+			case "br":
+				body = brotli.NewReader(rec.Body)
+			}
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if string(got) != testBody {
+				t.Fatalf("decoded body differs from the original")
+			}
+		})
+	}
+}
+
+// BenchmarkCompressHandler shows the CPU/size trade-off of
+// -compression-level. Compare ns/op with the reported compressed size:
+//
+//	go test ./cmd/server -run '^$' -bench CompressHandler
+func BenchmarkCompressHandler(b *testing.B) {
+	for _, bench := range []struct {
+		encoding string
+		levels   []int
+	}{
+		{encoding: "gzip", levels: []int{gzip.BestSpeed, 6, gzip.BestCompression}},
+		{encoding: "br", levels: []int{brotli.BestSpeed, 4, brotli.BestCompression}},
+	} {
+		for _, level := range bench.levels {
+			b.Run(fmt.Sprintf("%s=%d", bench.encoding, level), func(b *testing.B) {
+				levels, err := parseCompressionLevels(fmt.Sprintf("%s=%d", bench.encoding, level))
+				if err != nil {
+					b.Fatal(err)
+				}
+				handler := compressHandler(newEncoderPools(levels), http.HandlerFunc(bodyHandler))
+				req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+				req.Header.Set("Accept-Encoding", bench.encoding)
+
+				var size int
+				b.ReportAllocs()
+				for b.Loop() {
+					rec := httptest.NewRecorder()
+					handler.ServeHTTP(rec, req)
+					size = rec.Body.Len()
+				}
+				b.ReportMetric(float64(size), "compressed-bytes")
+				b.ReportMetric(float64(len(testBody))/float64(size), "ratio")
+			})
+		}
+	}
+}
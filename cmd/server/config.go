@@ -0,0 +1,608 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// maxGreetingSuffixLen bounds -greeting-suffix, in characters.
+const maxGreetingSuffixLen = 16
+
+// config holds the effective server configuration resolved from flags.
+type config struct {
+	// validate checks the configuration and exits instead of serving.
+	validate bool
+
+	httpAddr         string
+	metricsAddr      string
+	metricsPath      string
+	metricsRequired  bool
+	metricsNamespace string
+	metricsSubsystem string
+	// metricsMaxPaths caps distinct path label values; 0 is unlimited.
+	metricsMaxPaths  int
+	tcpKeepAlive     time.Duration
+	tcpTuning        bool
+	proxyProtocol    string
+	enableHotRestart bool
+	connMaxLifetime  time.Duration
+
+	shutdownTimeout          time.Duration
+	httpShutdownTimeout      time.Duration
+	metricsShutdownTimeout   time.Duration
+	postShutdownDelay        time.Duration
+	interruptShutdownTimeout time.Duration
+
+	latencyMetricType string
+	summaryObjectives map[float64]float64
+
+	cacheControl      string
+	compression       bool
+	compressionLevels map[string]int
+	greetingSuffix    string
+	verboseResponse   bool
+	serveUI           bool
+	prettyJSON        bool
+	// jsonContentType is the /hello Content-Type and jsonMediaType its
+	// media type without parameters, for Accept negotiation.
+	jsonContentType string
+	jsonMediaType   string
+	// defaultContentType is the probe format served when Accept is absent.
+	defaultContentType string
+	trailingSlashMode  string
+	// apiPrefix versions the API routes, e.g. /v1; empty serves them
+	// unversioned only.
+	apiPrefix        string
+	unversionedAlias bool
+	nameTransforms   []func(string) string
+	// nameTransformNames are the -name-transforms entries, for logging.
+	nameTransformNames []string
+	requireName        bool
+	multiNameMode      string
+	// deprecatedParams are /hello query parameters that still work but
+	// get a Warning header.
+	deprecatedParams []string
+
+	dbDSN          string
+	dbQueryTimeout time.Duration
+	dbMaxOpenConns int
+	// userServiceURL is the base URL of an HTTP user profile service, an
+	// alternative to dbDSN.
+	userServiceURL string
+	// breakerThreshold consecutive lookup failures open the user store's
+	// circuit breaker for breakerResetTimeout.
+	breakerThreshold    int
+	breakerResetTimeout time.Duration
+	userIDHeader        string
+	requestIDHeader     string
+	// serverHeader is the Server response header; empty strips it.
+	serverHeader string
+
+	outboundTimeout             time.Duration
+	outboundMaxIdleConns        int
+	outboundMaxIdleConnsPerHost int
+	outboundIdleConnTimeout     time.Duration
+
+	injectLatency       time.Duration
+	injectLatencyJitter time.Duration
+	injectErrorRate     float64
+	injectErrorSeed     uint64
+
+	globalRateLimit float64
+	globalRateBurst int
+
+	memoryLimit          uint64
+	memoryShedPercent    float64
+	memorySampleInterval time.Duration
+
+	logOutput string
+	logBodies bool
+	// accessLogSampleRate is the fraction of successful requests logged by
+	// -access-log; errors are always logged.
+	accessLog           bool
+	accessLogSampleRate float64
+	accessLogSampleSeed uint64
+	logBodyMaxBytes     int
+
+	tlsCertFile     string
+	tlsKeyFile      string
+	tlsMinVersion   uint16
+	tlsCipherSuites []uint16
+	tlsCurves       []tls.CurveID
+	// tlsReloadInterval is how often the certificate files are checked for
+	// changes; 0 reloads only on SIGHUP.
+	tlsReloadInterval time.Duration
+
+	healthCheckTimeout time.Duration
+
+	tracingRequired       bool
+	traceExcludePaths     map[string]bool
+	propagators           []string
+	traceHeaderAttributes []headerAttribute
+	// traceBatchSize, traceBatchTimeout and traceMaxQueueSize tune the
+	// batch span processor; the defaults are the SDK's.
+	traceBatchSize    int
+	traceBatchTimeout time.Duration
+	traceMaxQueueSize int
+	// traceSampleRatio is the fraction of new traces sampled; requests
+	// carrying a debug signal are always sampled with traceForceSampling.
+	traceSampleRatio   float64
+	traceForceSampling bool
+	otelMetrics        bool
+
+	enableDebug bool
+	debugToken  string
+}
+
+// parseConfig registers the server flags on fs, parses args and validates
+// the result.
+func parseConfig(fs *flag.FlagSet, args []string) (*config, error) {
+	cfg := &config{}
+	var objectives string
+
+	fs.BoolVar(&cfg.validate, "validate", false, "Check the configuration, bind the listeners briefly and exit without serving")
+	fs.StringVar(&cfg.httpAddr, "http-addr", defaultHTTPAddr, "HTTP listen address")
+	fs.StringVar(&cfg.metricsAddr, "metrics-addr", defaultMetricsAddr, "Prometheus metrics listen address")
+	fs.StringVar(&cfg.metricsPath, "metrics-path", "/metrics", "Path of the Prometheus endpoint on the metrics listener")
+	fs.BoolVar(&cfg.metricsRequired, "metrics-required", true, "Exit if the metrics server fails; when false the HTTP server keeps serving without metrics")
+	fs.StringVar(&cfg.metricsNamespace, "metrics-namespace", "", "Namespace prefix for exported metric names")
+	fs.StringVar(&cfg.metricsSubsystem, "metrics-subsystem", "", "Subsystem prefix for exported HTTP metric names")
+	fs.IntVar(&cfg.metricsMaxPaths, "metrics-max-paths", 16, "Maximum distinct path label values handed out as routes are registered at startup; further routes are counted as \"other\" (0 is unlimited)")
+	fs.StringVar(&cfg.latencyMetricType, "latency-metric-type", "histogram", "Latency metric type: histogram or summary")
+	fs.StringVar(&objectives, "latency-summary-objectives", "0.5:0.05,0.9:0.01,0.99:0.001", "Comma-separated quantile:error objectives used when -latency-metric-type=summary")
+	fs.StringVar(&cfg.cacheControl, "cache-control", "", "Cache-Control header value for /hello responses (empty sends none)")
+	fs.BoolVar(&cfg.compression, "compression", false, "Compress responses with br or gzip as negotiated by Accept-Encoding")
+	compressionLevels := fs.String("compression-level", "", "Comma-separated encoding=level pairs, e.g. br=5,gzip=4 (br 0-11, gzip 1-9; unlisted encodings use br=4, gzip=6)")
+	fs.StringVar(&cfg.greetingSuffix, "greeting-suffix", "", "Text appended to every greeting, e.g. \"!\"")
+	fs.BoolVar(&cfg.verboseResponse, "verbose-response", false, "Include diagnostic fields such as name and served_by in /hello responses")
+	fs.BoolVar(&cfg.prettyJSON, "pretty-json", false, "Indent /hello JSON responses for readability")
+	fs.StringVar(&cfg.defaultContentType, "default-content-type", "application/json", "Health probe response format when the request has no Accept header: application/json or text/plain")
+	fs.StringVar(&cfg.jsonContentType, "json-content-type", "application/json", "Content-Type of /hello responses, e.g. a vendor type like application/vnd.greeting.v1+json")
+	deprecatedParams := fs.String("deprecated-params", "", "Comma-separated /hello query parameters that are still honored but answered with a Warning header")
+	nameTransforms := fs.String("name-transforms", "", "Comma-separated transforms applied in order to the resolved name: trim, titlecase, nfc, stripemoji")
+	fs.StringVar(&cfg.apiPrefix, "api-prefix", "", "Version prefix for API routes, e.g. /v1 serves /v1/hello (empty serves /hello only)")
+	fs.BoolVar(&cfg.unversionedAlias, "unversioned-alias", true, "With -api-prefix, also serve the API routes at their unversioned paths, e.g. /hello")
+	fs.StringVar(&cfg.trailingSlashMode, "trailing-slash-mode", "strict", "Handling of a trailing slash on a route, e.g. /hello/: strict (404), redirect (308 to /hello) or ignore (served as /hello)")
+	fs.BoolVar(&cfg.serveUI, "serve-ui", false, "Serve a demo page at / and a favicon at /favicon.ico")
+	fs.BoolVar(&cfg.requireName, "require-name", false, "Reject /hello requests without a name with 400 instead of greeting World")
+	fs.StringVar(&cfg.multiNameMode, "multi-name-mode", "first", "How repeated name parameters are handled: first (ignore the rest) or all (greet everyone)")
+	fs.StringVar(&cfg.dbDSN, "db-dsn", "", "Postgres DSN for personalized greetings (empty disables)")
+	fs.DurationVar(&cfg.dbQueryTimeout, "db-query-timeout", 500*time.Millisecond, "Timeout for each user profile lookup")
+	fs.IntVar(&cfg.dbMaxOpenConns, "db-max-open-conns", 10, "Maximum open connections in the user database pool")
+	fs.StringVar(&cfg.userServiceURL, "user-service-url", "", "Base URL of an HTTP user profile service for personalized greetings (empty disables)")
+	fs.IntVar(&cfg.breakerThreshold, "breaker-failure-threshold", 5, "Consecutive user profile lookup failures that open the circuit breaker")
+	fs.DurationVar(&cfg.breakerResetTimeout, "breaker-reset-timeout", 30*time.Second, "How long the open circuit breaker skips user profile lookups before trying one again")
+	fs.StringVar(&cfg.userIDHeader, "user-id-header", "X-User-Id", "Request header carrying the user ID for personalized greetings")
+	fs.StringVar(&cfg.serverHeader, "server-header", "", "Server header sent on every response on both listeners (empty removes it)")
+	fs.StringVar(&cfg.requestIDHeader, "request-id-header", "X-Request-Id", "Header a request ID is read from and echoed back in; one is generated when absent")
+	fs.DurationVar(&cfg.outboundTimeout, "outbound-timeout", 5*time.Second, "Overall timeout for each call to a downstream service")
+	fs.IntVar(&cfg.outboundMaxIdleConns, "outbound-max-idle-conns", 100, "Maximum idle downstream connections kept across all hosts (0 is unlimited)")
+	fs.IntVar(&cfg.outboundMaxIdleConnsPerHost, "outbound-max-idle-conns-per-host", 10, "Maximum idle downstream connections kept per host")
+	fs.DurationVar(&cfg.outboundIdleConnTimeout, "outbound-idle-conn-timeout", 90*time.Second, "How long an idle downstream connection is kept before closing (0 keeps it indefinitely)")
+	fs.DurationVar(&cfg.injectLatency, "inject-latency", 0, "Chaos testing: delay every /hello response by this long")
+	fs.DurationVar(&cfg.injectLatencyJitter, "inject-latency-jitter", 0, "Chaos testing: add a random extra delay of up to this long")
+	fs.Float64Var(&cfg.injectErrorRate, "inject-error-rate", 0, "Chaos testing: fraction (0-1) of /hello requests that fail with 500")
+	fs.Uint64Var(&cfg.injectErrorSeed, "inject-error-seed", 0, "Chaos testing: seed for -inject-error-rate to make failures reproducible (0 is random)")
+	fs.Float64Var(&cfg.globalRateLimit, "global-rate-limit", 0, "Maximum /hello requests per second across all clients (0 disables)")
+	fs.IntVar(&cfg.globalRateBurst, "global-rate-burst", 0, "Requests allowed in a burst above -global-rate-limit (0 uses the rate, rounded up)")
+	memoryLimit := fs.String("memory-limit", "", "Memory budget for load shedding, e.g. 512MiB (empty disables shedding)")
+	fs.Float64Var(&cfg.memoryShedPercent, "memory-shed-threshold", 90, "Shed /hello requests with 503 while the heap is above this percentage of -memory-limit")
+	fs.DurationVar(&cfg.memorySampleInterval, "memory-sample-interval", time.Second, "How often heap usage is sampled for load shedding")
+	fs.StringVar(&cfg.logOutput, "log-output", "stderr", "Log destination: stderr, stdout or a file path (appended to and reopened on SIGHUP)")
+	fs.BoolVar(&cfg.accessLog, "access-log", false, "Log one line per request on the HTTP listener")
+	fs.Float64Var(&cfg.accessLogSampleRate, "access-log-sample-rate", 1, "Fraction of successful requests logged by -access-log, between 0 and 1; 4xx and 5xx are always logged")
+	fs.Uint64Var(&cfg.accessLogSampleSeed, "access-log-sample-seed", 0, "Seed for -access-log-sample-rate to make sampling reproducible (0 is random)")
+	fs.BoolVar(&cfg.logBodies, "log-bodies", false, "Log request and response bodies for debugging (privacy sensitive)")
+	fs.IntVar(&cfg.logBodyMaxBytes, "log-body-max-bytes", 4096, "Maximum bytes of each body logged by -log-bodies")
+	fs.StringVar(&cfg.tlsCertFile, "tls-cert-file", "", "PEM certificate for serving HTTPS on -http-addr (empty serves plain HTTP)")
+	fs.StringVar(&cfg.tlsKeyFile, "tls-key-file", "", "PEM private key matching -tls-cert-file")
+	fs.DurationVar(&cfg.tlsReloadInterval, "tls-reload-interval", 0, "Poll the TLS certificate files this often and reload them when they change (0 reloads only on SIGHUP)")
+	tlsMinVersion := fs.String("tls-min-version", "1.2", "Minimum TLS version: 1.2 or 1.3")
+	tlsCiphers := fs.String("tls-ciphers", "", "Comma-separated TLS 1.2 cipher suite names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (empty uses Go defaults)")
+	tlsCurves := fs.String("tls-curves", "", "Comma-separated key exchange curves: X25519, X25519MLKEM768, P256, P384, P521 (empty uses Go defaults)")
+	fs.DurationVar(&cfg.healthCheckTimeout, "health-check-timeout", 2*time.Second, "Timeout for each subsystem check run by /health/detailed")
+	fs.BoolVar(&cfg.tracingRequired, "tracing-required", false, "Hold back /hello and fail health probes until span export is confirmed and while it is failing")
+	traceExclude := fs.String("trace-exclude-paths", "", "Comma-separated request paths that never create spans (default /healthz, /readyz and -metrics-path, with their -api-prefix forms)")
+	traceHeaderAttributes := fs.String("trace-header-attributes", "", "Comma-separated Header:attribute.key mappings copied from requests onto spans, e.g. X-Tenant-Id:tenant.id")
+	fs.IntVar(&cfg.traceBatchSize, "trace-batch-size", sdktrace.DefaultMaxExportBatchSize, "Maximum number of spans per OTLP export")
+	fs.DurationVar(&cfg.traceBatchTimeout, "trace-batch-timeout", sdktrace.DefaultScheduleDelay*time.Millisecond, "Longest a span waits in the queue before a partial batch is exported")
+	fs.Float64Var(&cfg.traceSampleRatio, "trace-sample-ratio", 1, "Fraction of new traces to sample, between 0 and 1; incoming sampled parents are always followed")
+	fs.BoolVar(&cfg.traceForceSampling, "trace-force-sampling", false, "Always sample requests with ?debug=true or an X-Debug: 1 header")
+	fs.IntVar(&cfg.traceMaxQueueSize, "trace-max-queue-size", sdktrace.DefaultMaxQueueSize, "Spans buffered for export; further spans are dropped while the queue is full")
+	propagators := fs.String("propagators", "tracecontext,baggage", "Comma-separated trace context propagators: tracecontext, baggage, b3")
+	fs.BoolVar(&cfg.otelMetrics, "otel-metrics", false, "Also export request metrics over OTLP alongside Prometheus")
+	fs.BoolVar(&cfg.enableDebug, "enable-debug-endpoints", false, "Serve /debug endpoints on the metrics listener")
+	fs.StringVar(&cfg.debugToken, "debug-token", "", "Bearer token required by /debug endpoints")
+	fs.DurationVar(&cfg.tcpKeepAlive, "tcp-keepalive", 0, "TCP keep-alive period for accepted connections (0 uses the Go default, negative disables)")
+
+	fs.BoolVar(&cfg.tcpTuning, "tcp-tuning", false, "Enable platform TCP listener tuning (TCP_DEFER_ACCEPT on Linux)")
+	fs.StringVar(&cfg.proxyProtocol, "proxy-protocol", "off", "Accept PROXY protocol v1/v2 headers on -http-addr: off, optional or required")
+	fs.BoolVar(&cfg.enableHotRestart, "enable-hot-restart", false, "Re-exec with inherited listeners on SIGUSR2 for zero-downtime upgrades (Linux only)")
+	fs.DurationVar(&cfg.connMaxLifetime, "connection-max-lifetime", 0, "Close client connections open longer than this (0 disables)")
+	fs.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 5*time.Second, "Default graceful shutdown deadline for each server")
+	fs.DurationVar(&cfg.httpShutdownTimeout, "http-shutdown-timeout", 0, "Graceful shutdown deadline for the HTTP server (0 uses -shutdown-timeout)")
+	fs.DurationVar(&cfg.metricsShutdownTimeout, "metrics-shutdown-timeout", 0, "Graceful shutdown deadline for the metrics server (0 uses -shutdown-timeout)")
+	fs.DurationVar(&cfg.interruptShutdownTimeout, "interrupt-shutdown-timeout", time.Second, "Shorter drain deadline for each server when shutdown is started by SIGINT")
+	fs.DurationVar(&cfg.postShutdownDelay, "post-shutdown-delay", 0, "Wait this long after shutdown completes before exiting, so sidecars can finish their own drains")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if cfg.enableHotRestart && !hotRestartSupported {
+		return nil, fmt.Errorf("-enable-hot-restart is only supported on Linux")
+	}
+	if _, ok := proxyProtocolPolicies[cfg.proxyProtocol]; !ok && cfg.proxyProtocol != "off" {
+		return nil, fmt.Errorf("invalid -proxy-protocol %q: must be off, optional or required", cfg.proxyProtocol)
+	}
+	if !isRoutePath(cfg.metricsPath) || cfg.metricsPath == "/healthz" || strings.HasPrefix(cfg.metricsPath, "/debug/") {
+		return nil, fmt.Errorf("invalid -metrics-path %q: must be a clean path such as /metrics, without spaces or braces, and not /healthz or under /debug/", cfg.metricsPath)
+	}
+	if strings.ContainsAny(cfg.serverHeader, "\r\n") {
+		return nil, fmt.Errorf("invalid -server-header %q: must be a single line", cfg.serverHeader)
+	}
+	if cfg.requestIDHeader == "" || strings.ContainsAny(cfg.requestIDHeader, " \t:") {
+		return nil, fmt.Errorf("invalid -request-id-header %q: must be a header name", cfg.requestIDHeader)
+	}
+	if cfg.metricsMaxPaths < 0 {
+		return nil, fmt.Errorf("invalid -metrics-max-paths %d: must not be negative", cfg.metricsMaxPaths)
+	}
+	if cfg.enableDebug && cfg.debugToken == "" {
+		return nil, fmt.Errorf("-enable-debug-endpoints requires -debug-token")
+	}
+	if cfg.shutdownTimeout <= 0 {
+		return nil, fmt.Errorf("invalid -shutdown-timeout %s: must be positive", cfg.shutdownTimeout)
+	}
+	if cfg.httpShutdownTimeout <= 0 {
+		cfg.httpShutdownTimeout = cfg.shutdownTimeout
+	}
+	if cfg.interruptShutdownTimeout <= 0 {
+		return nil, fmt.Errorf("invalid -interrupt-shutdown-timeout %s: must be positive", cfg.interruptShutdownTimeout)
+	}
+	if cfg.postShutdownDelay < 0 {
+		return nil, fmt.Errorf("invalid -post-shutdown-delay %s: must not be negative", cfg.postShutdownDelay)
+	}
+	if cfg.metricsShutdownTimeout <= 0 {
+		cfg.metricsShutdownTimeout = cfg.shutdownTimeout
+	}
+
+	if !slices.Contains(healthContentTypes, cfg.defaultContentType) {
+		return nil, fmt.Errorf("invalid -default-content-type %q: must be application/json or text/plain", cfg.defaultContentType)
+	}
+	mediaType, _, err := mime.ParseMediaType(cfg.jsonContentType)
+	if err != nil || (mediaType != "application/json" && !(strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"))) {
+		return nil, fmt.Errorf("invalid -json-content-type %q: must be application/json or an application/*+json media type", cfg.jsonContentType)
+	}
+	cfg.jsonMediaType = mediaType
+
+	if !utf8.ValidString(cfg.greetingSuffix) || utf8.RuneCountInString(cfg.greetingSuffix) > maxGreetingSuffixLen {
+		return nil, fmt.Errorf("invalid -greeting-suffix %q: must be valid UTF-8 of at most %d characters", cfg.greetingSuffix, maxGreetingSuffixLen)
+	}
+
+	for _, name := range strings.Split(*nameTransforms, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		transform, ok := nameTransformsByName[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid -name-transforms entry %q: must be one of trim, titlecase, nfc, stripemoji", name)
+		}
+		cfg.nameTransforms = append(cfg.nameTransforms, transform)
+		cfg.nameTransformNames = append(cfg.nameTransformNames, name)
+	}
+
+	for _, param := range strings.Split(*deprecatedParams, ",") {
+		if param = strings.TrimSpace(param); param != "" && !slices.Contains(cfg.deprecatedParams, param) {
+			cfg.deprecatedParams = append(cfg.deprecatedParams, param)
+		}
+	}
+
+	if cfg.apiPrefix != "" && (!isRoutePath(cfg.apiPrefix) || cfg.apiPrefix == "/") {
+		return nil, fmt.Errorf("invalid -api-prefix %q: must be a clean path such as /v1, without a trailing slash", cfg.apiPrefix)
+	}
+	if cfg.trailingSlashMode != "strict" && cfg.trailingSlashMode != "redirect" && cfg.trailingSlashMode != "ignore" {
+		return nil, fmt.Errorf("invalid -trailing-slash-mode %q: must be strict, redirect or ignore", cfg.trailingSlashMode)
+	}
+
+	levels, err := parseCompressionLevels(*compressionLevels)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -compression-level: %w", err)
+	}
+	cfg.compressionLevels = levels
+
+	if cfg.multiNameMode != "first" && cfg.multiNameMode != "all" {
+		return nil, fmt.Errorf("invalid -multi-name-mode %q: must be first or all", cfg.multiNameMode)
+	}
+
+	if cfg.userServiceURL != "" {
+		if cfg.dbDSN != "" {
+			return nil, fmt.Errorf("-user-service-url and -db-dsn are mutually exclusive")
+		}
+		u, err := url.Parse(cfg.userServiceURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("invalid -user-service-url %q: must be an absolute http or https URL without query", cfg.userServiceURL)
+		}
+		cfg.userServiceURL = strings.TrimSuffix(cfg.userServiceURL, "/")
+	}
+	if cfg.breakerThreshold <= 0 || cfg.breakerResetTimeout <= 0 {
+		return nil, fmt.Errorf("invalid -breaker-failure-threshold/-breaker-reset-timeout: must be positive")
+	}
+	if cfg.dbQueryTimeout <= 0 {
+		return nil, fmt.Errorf("invalid -db-query-timeout %s: must be positive", cfg.dbQueryTimeout)
+	}
+	if cfg.dbMaxOpenConns <= 0 {
+		return nil, fmt.Errorf("invalid -db-max-open-conns %d: must be positive", cfg.dbMaxOpenConns)
+	}
+
+	if cfg.outboundTimeout <= 0 {
+		return nil, fmt.Errorf("invalid -outbound-timeout %s: must be positive", cfg.outboundTimeout)
+	}
+
+	if cfg.outboundMaxIdleConns < 0 || cfg.outboundIdleConnTimeout < 0 {
+		return nil, fmt.Errorf("invalid -outbound-max-idle-conns/-outbound-idle-conn-timeout: must not be negative")
+	}
+	if cfg.outboundMaxIdleConnsPerHost <= 0 {
+		return nil, fmt.Errorf("invalid -outbound-max-idle-conns-per-host %d: must be positive", cfg.outboundMaxIdleConnsPerHost)
+	}
+
+	if cfg.injectLatency < 0 || cfg.injectLatencyJitter < 0 {
+		return nil, fmt.Errorf("invalid -inject-latency/-inject-latency-jitter: must not be negative")
+	}
+
+	if cfg.injectErrorRate < 0 || cfg.injectErrorRate > 1 {
+		return nil, fmt.Errorf("invalid -inject-error-rate %v: must be between 0 and 1", cfg.injectErrorRate)
+	}
+
+	if cfg.globalRateLimit < 0 || cfg.globalRateBurst < 0 {
+		return nil, fmt.Errorf("invalid -global-rate-limit/-global-rate-burst: must not be negative")
+	}
+	if cfg.globalRateLimit > 0 && cfg.globalRateBurst == 0 {
+		cfg.globalRateBurst = int(math.Ceil(cfg.globalRateLimit))
+	}
+
+	if *memoryLimit != "" {
+		limit, err := parseByteSize(*memoryLimit)
+		if err != nil || limit == 0 {
+			return nil, fmt.Errorf("invalid -memory-limit %q: must be a positive size like 512MiB", *memoryLimit)
+		}
+		cfg.memoryLimit = limit
+	}
+	if cfg.memoryShedPercent <= 0 || cfg.memoryShedPercent > 100 {
+		return nil, fmt.Errorf("invalid -memory-shed-threshold %v: must be in (0, 100]", cfg.memoryShedPercent)
+	}
+	if cfg.memorySampleInterval <= 0 {
+		return nil, fmt.Errorf("invalid -memory-sample-interval %s: must be positive", cfg.memorySampleInterval)
+	}
+
+	if math.IsNaN(cfg.accessLogSampleRate) || cfg.accessLogSampleRate < 0 || cfg.accessLogSampleRate > 1 {
+		return nil, fmt.Errorf("invalid -access-log-sample-rate %v: must be between 0 and 1", cfg.accessLogSampleRate)
+	}
+	if cfg.logBodyMaxBytes <= 0 {
+		return nil, fmt.Errorf("invalid -log-body-max-bytes %d: must be positive", cfg.logBodyMaxBytes)
+	}
+
+	if (cfg.tlsCertFile == "") != (cfg.tlsKeyFile == "") {
+		return nil, fmt.Errorf("-tls-cert-file and -tls-key-file must be set together")
+	}
+	if cfg.tlsReloadInterval < 0 {
+		return nil, fmt.Errorf("invalid -tls-reload-interval %s: must not be negative", cfg.tlsReloadInterval)
+	}
+	version, ok := tlsVersionsByName[*tlsMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid -tls-min-version %q: must be 1.2 or 1.3", *tlsMinVersion)
+	}
+	cfg.tlsMinVersion = version
+	suites, err := parseCipherSuites(*tlsCiphers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -tls-ciphers: %w", err)
+	}
+	if len(suites) > 0 && version == tls.VersionTLS13 {
+		return nil, fmt.Errorf("invalid -tls-ciphers: cipher suites cannot be configured with -tls-min-version=1.3")
+	}
+	cfg.tlsCipherSuites = suites
+	curves, err := parseCurves(*tlsCurves)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -tls-curves: %w", err)
+	}
+	cfg.tlsCurves = curves
+	if cfg.tlsCertFile == "" && (len(suites) > 0 || len(curves) > 0) {
+		return nil, fmt.Errorf("-tls-ciphers and -tls-curves require -tls-cert-file and -tls-key-file")
+	}
+
+	if cfg.healthCheckTimeout <= 0 {
+		return nil, fmt.Errorf("invalid -health-check-timeout %s: must be positive", cfg.healthCheckTimeout)
+	}
+
+	if cfg.traceBatchSize <= 0 {
+		return nil, fmt.Errorf("invalid -trace-batch-size %d: must be positive", cfg.traceBatchSize)
+	}
+	if cfg.traceBatchTimeout <= 0 {
+		return nil, fmt.Errorf("invalid -trace-batch-timeout %s: must be positive", cfg.traceBatchTimeout)
+	}
+	if math.IsNaN(cfg.traceSampleRatio) || cfg.traceSampleRatio < 0 || cfg.traceSampleRatio > 1 {
+		return nil, fmt.Errorf("invalid -trace-sample-ratio %v: must be between 0 and 1", cfg.traceSampleRatio)
+	}
+	if cfg.traceMaxQueueSize < cfg.traceBatchSize {
+		return nil, fmt.Errorf("invalid -trace-max-queue-size %d: must be at least -trace-batch-size (%d)", cfg.traceMaxQueueSize, cfg.traceBatchSize)
+	}
+
+	cfg.traceExcludePaths = make(map[string]bool)
+	if flagSet(fs, "trace-exclude-paths") {
+		for _, path := range strings.Split(*traceExclude, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				cfg.traceExcludePaths[path] = true
+			}
+		}
+	} else {
+		for _, path := range []string{"/healthz", "/readyz", cfg.metricsPath} {
+			for _, resolved := range apiPaths(cfg, path) {
+				cfg.traceExcludePaths[resolved] = true
+			}
+			// The probes are served unversioned even without the alias.
+			cfg.traceExcludePaths[path] = true
+		}
+	}
+
+	cfg.traceHeaderAttributes, err = parseHeaderAttributes(*traceHeaderAttributes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -trace-header-attributes: %w", err)
+	}
+
+	for _, name := range strings.Split(*propagators, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := propagatorsByName[name]; !ok {
+			return nil, fmt.Errorf("invalid -propagators entry %q: must be one of tracecontext, baggage, b3", name)
+		}
+		cfg.propagators = append(cfg.propagators, name)
+	}
+
+	switch cfg.latencyMetricType {
+	case "histogram":
+	case "summary":
+		parsed, err := parseObjectives(objectives)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -latency-summary-objectives: %w", err)
+		}
+		cfg.summaryObjectives = parsed
+	default:
+		return nil, fmt.Errorf("invalid -latency-metric-type %q: must be histogram or summary", cfg.latencyMetricType)
+	}
+
+	return cfg, nil
+}
+
+// logValue summarizes the effective configuration for the startup log.
+// Secrets are redacted.
+func (c *config) logValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("http_addr", c.httpAddr),
+		slog.String("metrics_addr", c.metricsAddr),
+		slog.String("metrics_path", c.metricsPath),
+		slog.Bool("metrics_required", c.metricsRequired),
+		slog.String("metrics_namespace", c.metricsNamespace),
+		slog.String("metrics_subsystem", c.metricsSubsystem),
+		slog.Int("metrics_max_paths", c.metricsMaxPaths),
+		slog.Duration("tcp_keepalive", c.tcpKeepAlive),
+		slog.Bool("tcp_tuning", c.tcpTuning),
+		slog.String("proxy_protocol", c.proxyProtocol),
+		slog.Duration("connection_max_lifetime", c.connMaxLifetime),
+		slog.Bool("hot_restart", c.enableHotRestart),
+		slog.Duration("http_shutdown_timeout", c.httpShutdownTimeout),
+		slog.Duration("metrics_shutdown_timeout", c.metricsShutdownTimeout),
+		slog.Duration("interrupt_shutdown_timeout", c.interruptShutdownTimeout),
+		slog.Duration("post_shutdown_delay", c.postShutdownDelay),
+		slog.String("latency_metric_type", c.latencyMetricType),
+		slog.String("cache_control", c.cacheControl),
+		slog.Bool("compression", c.compression),
+		slog.Any("compression_level", c.compressionLevels),
+		slog.String("greeting_suffix", c.greetingSuffix),
+		slog.Bool("verbose_response", c.verboseResponse),
+		slog.Bool("serve_ui", c.serveUI),
+		slog.Bool("pretty_json", c.prettyJSON),
+		slog.String("json_content_type", c.jsonContentType),
+		slog.String("default_content_type", c.defaultContentType),
+		slog.String("api_prefix", c.apiPrefix),
+		slog.Bool("unversioned_alias", c.unversionedAlias),
+		slog.String("trailing_slash_mode", c.trailingSlashMode),
+		slog.Any("name_transforms", c.nameTransformNames),
+		slog.Any("deprecated_params", c.deprecatedParams),
+		slog.Bool("require_name", c.requireName),
+		slog.String("multi_name_mode", c.multiNameMode),
+		slog.String("otlp_endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")),
+		slog.String("db_dsn", redact(c.dbDSN)),
+		slog.Duration("db_query_timeout", c.dbQueryTimeout),
+		slog.Int("db_max_open_conns", c.dbMaxOpenConns),
+		slog.String("user_service_url", c.userServiceURL),
+		slog.Int("breaker_failure_threshold", c.breakerThreshold),
+		slog.Duration("breaker_reset_timeout", c.breakerResetTimeout),
+		slog.String("user_id_header", c.userIDHeader),
+		slog.String("request_id_header", c.requestIDHeader),
+		slog.String("server_header", c.serverHeader),
+		slog.Duration("outbound_timeout", c.outboundTimeout),
+		slog.Int("outbound_max_idle_conns", c.outboundMaxIdleConns),
+		slog.Int("outbound_max_idle_conns_per_host", c.outboundMaxIdleConnsPerHost),
+		slog.Duration("outbound_idle_conn_timeout", c.outboundIdleConnTimeout),
+		slog.Duration("inject_latency", c.injectLatency),
+		slog.Duration("inject_latency_jitter", c.injectLatencyJitter),
+		slog.Float64("inject_error_rate", c.injectErrorRate),
+		slog.Float64("global_rate_limit", c.globalRateLimit),
+		slog.Int("global_rate_burst", c.globalRateBurst),
+		slog.Uint64("memory_limit", c.memoryLimit),
+		slog.Float64("memory_shed_threshold", c.memoryShedPercent),
+		slog.Duration("memory_sample_interval", c.memorySampleInterval),
+		slog.String("log_output", c.logOutput),
+		slog.Bool("access_log", c.accessLog),
+		slog.Float64("access_log_sample_rate", c.accessLogSampleRate),
+		slog.Uint64("access_log_sample_seed", c.accessLogSampleSeed),
+		slog.Bool("log_bodies", c.logBodies),
+		slog.Int("log_body_max_bytes", c.logBodyMaxBytes),
+		slog.String("tls_cert_file", c.tlsCertFile),
+		slog.Duration("tls_reload_interval", c.tlsReloadInterval),
+		slog.String("tls_min_version", tls.VersionName(c.tlsMinVersion)),
+		slog.Any("tls_ciphers", tlsNames(c.tlsCipherSuites, tls.CipherSuiteName)),
+		slog.Any("tls_curves", tlsNames(c.tlsCurves, tls.CurveID.String)),
+		slog.Duration("health_check_timeout", c.healthCheckTimeout),
+		slog.Bool("tracing_required", c.tracingRequired),
+		slog.Any("trace_exclude_paths", slices.Sorted(maps.Keys(c.traceExcludePaths))),
+		slog.Int("trace_batch_size", c.traceBatchSize),
+		slog.Duration("trace_batch_timeout", c.traceBatchTimeout),
+		slog.Int("trace_max_queue_size", c.traceMaxQueueSize),
+		slog.Float64("trace_sample_ratio", c.traceSampleRatio),
+		slog.Bool("trace_force_sampling", c.traceForceSampling),
+		slog.Any("propagators", c.propagators),
+		slog.Any("trace_header_attributes", c.traceHeaderAttributeSpecs()),
+		slog.Bool("otel_metrics", c.otelMetrics),
+		slog.Bool("debug_endpoints", c.enableDebug),
+		slog.String("debug_token", redact(c.debugToken)),
+	)
+}
+
+// redact hides a secret value while still showing whether it is set.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+// traceHeaderAttributeSpecs renders -trace-header-attributes for logging.
+func (c *config) traceHeaderAttributeSpecs() []string {
+	specs := make([]string, 0, len(c.traceHeaderAttributes))
+	for _, m := range c.traceHeaderAttributes {
+		specs = append(specs, m.header+":"+string(m.key))
+	}
+	return specs
+}
+
+// isRoutePath reports whether p can be used verbatim as the path of a
+// ServeMux pattern: absolute and clean, without whitespace, which would
+// make the part before it a method, or braces, which start a wildcard.
+func isRoutePath(p string) bool {
+	return strings.HasPrefix(p, "/") && path.Clean(p) == p &&
+		!strings.ContainsAny(p, "{}") && !strings.ContainsFunc(p, unicode.IsSpace)
+}
+
+// flagSet reports whether the flag called name was given on the command
+// line, as opposed to left at its default.
+func flagSet(fs *flag.FlagSet, name string) bool {
+	var set bool
+	fs.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
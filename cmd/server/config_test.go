@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// parseTestConfig parses args on a fresh flag set, as main does with the
+// command line.
+func parseTestConfig(t testing.TB, args ...string) *config {
+	t.Helper()
+	cfg, err := parseConfig(newTestFlagSet(), args)
+	if err != nil {
+		t.Fatalf("parseConfig(%q): %v", args, err)
+	}
+	return cfg
+}
+
+func newTestFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "debug endpoints without token",
+			args: []string{"-enable-debug-endpoints"},
+			want: "-enable-debug-endpoints requires -debug-token",
+		},
+		{
+			name: "metrics path with a method",
+			args: []string{"-metrics-path", "/GET /metrics"},
+			want: "invalid -metrics-path",
+		},
+		{
+			name: "metrics path with a method prefix",
+			args: []string{"-metrics-path", "GET /metrics"},
+			want: "invalid -metrics-path",
+		},
+		{
+			name: "metrics path with a wildcard",
+			args: []string{"-metrics-path", "/metrics/{name}"},
+			want: "invalid -metrics-path",
+		},
+		{
+			name: "metrics path with a tab",
+			args: []string{"-metrics-path", "/met\trics"},
+			want: "invalid -metrics-path",
+		},
+		{
+			name: "unclean metrics path",
+			args: []string{"-metrics-path", "/internal//metrics/"},
+			want: "invalid -metrics-path",
+		},
+		{
+			name: "api prefix with a space",
+			args: []string{"-api-prefix", "/v 1"},
+			want: "invalid -api-prefix",
+		},
+		{
+			name: "unsupported default content type",
+			args: []string{"-default-content-type", "text/html"},
+			want: "invalid -default-content-type",
+		},
+		{
+			name: "user service without scheme",
+			args: []string{"-user-service-url", "users.internal:8080"},
+			want: "invalid -user-service-url",
+		},
+		{
+			name: "user service and database",
+			args: []string{"-user-service-url", "http://users.internal", "-db-dsn", "postgres://db"},
+			want: "mutually exclusive",
+		},
+		{
+			name: "malformed trace header attribute",
+			args: []string{"-trace-header-attributes", "X-Tenant-Id"},
+			want: "invalid -trace-header-attributes",
+		},
+		{
+			name: "too many trace header attributes",
+			args: []string{"-trace-header-attributes", "A:a,B:b,C:c,D:d,E:e,F:f,G:g,H:h,I:i,J:j,K:k"},
+			want: "at most 10 mappings",
+		},
+		{
+			name: "zero idle connections per host",
+			args: []string{"-outbound-max-idle-conns-per-host", "0"},
+			want: "invalid -outbound-max-idle-conns-per-host",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfig(newTestFlagSet(), tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("parseConfig(%q) error = %v, want it to contain %q", tt.args, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigDebugEndpointsWithToken(t *testing.T) {
+	cfg := parseTestConfig(t, "-enable-debug-endpoints", "-debug-token", "s3cret")
+	if !cfg.enableDebug || cfg.debugToken != "s3cret" {
+		t.Fatalf("got enableDebug=%v debugToken=%q", cfg.enableDebug, cfg.debugToken)
+	}
+}
+
+func TestTraceExcludePathDefaults(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "defaults", want: []string{"/healthz", "/metrics", "/readyz"}},
+		{
+			name: "resolved under the prefix and metrics path",
+			args: []string{"-api-prefix", "/v1", "-metrics-path", "/internal/metrics"},
+			want: []string{"/healthz", "/internal/metrics", "/readyz", "/v1/healthz", "/v1/internal/metrics", "/v1/readyz"},
+		},
+		{name: "explicit list replaces the defaults", args: []string{"-api-prefix", "/v1", "-trace-exclude-paths", "/v1/hello"}, want: []string{"/v1/hello"}},
+		{name: "explicitly empty", args: []string{"-trace-exclude-paths", ""}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, tt.args...)
+			got := slices.Sorted(maps.Keys(cfg.traceExcludePaths))
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("traceExcludePaths = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
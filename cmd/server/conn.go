@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type connStartKey struct{}
+
+// connContext records when the underlying connection was accepted so
+// handlers can reason about a connection's total budget.
+func connContext(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, connStartKey{}, time.Now())
+}
+
+// connStartFromContext returns the time the request's connection was
+// accepted.
+func connStartFromContext(ctx context.Context) (time.Time, bool) {
+	start, ok := ctx.Value(connStartKey{}).(time.Time)
+	return start, ok
+}
+
+// connLifetime closes connections that have been open longer than
+// maxLifetime, so persistent clients cannot hold a connection forever.
+// Busy connections are asked to close after their current response; idle
+// ones are closed directly.
+type connLifetime struct {
+	maxLifetime time.Duration
+	conns       sync.Map // net.Conn -> *trackedConn
+}
+
+type trackedConn struct {
+	mu      sync.Mutex
+	state   http.ConnState
+	expired bool
+	timer   *time.Timer
+}
+
+// connState is installed as http.Server.ConnState.
+func (l *connLifetime) connState(c net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		tc := &trackedConn{state: state}
+		tc.timer = time.AfterFunc(l.maxLifetime, func() {
+			tc.mu.Lock()
+			tc.expired = true
+			idle := tc.state == http.StateIdle
+			tc.mu.Unlock()
+			if idle {
+				_ = c.Close()
+			}
+		})
+		l.conns.Store(c, tc)
+	case http.StateActive, http.StateIdle:
+		v, ok := l.conns.Load(c)
+		if !ok {
+			return
+		}
+		tc := v.(*trackedConn)
+		tc.mu.Lock()
+		tc.state = state
+		closeNow := tc.expired && state == http.StateIdle
+		tc.mu.Unlock()
+		if closeNow {
+			_ = c.Close()
+		}
+	case http.StateHijacked, http.StateClosed:
+		if v, ok := l.conns.LoadAndDelete(c); ok {
+			v.(*trackedConn).timer.Stop()
+		}
+	}
+}
+
+// enforce marks responses on connections past their lifetime with
+// "Connection: close" so the server closes them once the response is sent.
+func (l *connLifetime) enforce(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if start, ok := connStartFromContext(r.Context()); ok && time.Since(start) >= l.maxLifetime {
+			w.Header().Set("Connection", "close")
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+	"time"
+)
+
+func TestConnLifetimeEnforce(t *testing.T) {
+	l := &connLifetime{maxLifetime: time.Minute}
+	tests := []struct {
+		name      string
+		age       time.Duration
+		wantClose bool
+	}{
+		{name: "young connection", age: time.Second},
+		{name: "expired connection", age: 2 * time.Minute, wantClose: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+			req = req.WithContext(context.WithValue(req.Context(), connStartKey{}, time.Now().Add(-tt.age)))
+			rec := httptest.NewRecorder()
+			l.enforce(http.NotFoundHandler()).ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Connection") == "close"; got != tt.wantClose {
+				t.Fatalf("Connection: close sent = %v, want %v", got, tt.wantClose)
+			}
+		})
+	}
+}
+
+// TestConnLifetimeLongLivedConnection keeps one client connection alive
+// across requests and checks it is only reused within the lifetime.
+func TestConnLifetimeLongLivedConnection(t *testing.T) {
+	const lifetime = 200 * time.Millisecond
+	tests := []struct {
+		name string
+		// handlerDelay keeps the first request busy, so the lifetime can
+		// expire while the connection is active rather than idle.
+		handlerDelay time.Duration
+		pause        time.Duration
+		wantReused   bool
+	}{
+		{name: "within lifetime", wantReused: true},
+		{name: "expired while idle", pause: 2 * lifetime},
+		{name: "expired while active", handlerDelay: 2 * lifetime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &connLifetime{maxLifetime: lifetime}
+			first := true
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, ok := connStartFromContext(r.Context()); !ok {
+					t.Error("connection start missing from request context")
+				}
+				if first {
+					first = false
+					time.Sleep(tt.handlerDelay)
+				}
+			})
+			srv := httptest.NewUnstartedServer(l.enforce(handler))
+			srv.Config.ConnContext = connContext
+			srv.Config.ConnState = l.connState
+			srv.Start()
+			defer srv.Close()
+
+			client := srv.Client()
+			get := func() bool {
+				var reused bool
+				trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+				req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, srv.URL, nil)
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("GET: %v", err)
+				}
+				_, _ = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				return reused
+			}
+
+			get()
+			time.Sleep(tt.pause)
+			if got := get(); got != tt.wantReused {
+				t.Fatalf("second request reused connection = %v, want %v", got, tt.wantReused)
+			}
+		})
+	}
+}
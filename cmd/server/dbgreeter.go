@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" database/sql driver
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/example/rest-greeting/internal/circuitbreaker"
+)
+
+// errUserNotFound is returned by a userStore when the user has no profile.
+var errUserNotFound = errors.New("user not found")
+
+// userProfile is the personalization stored for a user.
+type userProfile struct {
+	nickname string
+	language string
+}
+
+// userStore looks up user profiles.
+type userStore interface {
+	lookupUser(ctx context.Context, userID string) (userProfile, error)
+}
+
+// sqlUserStore reads profiles from the user_greetings table:
+//
+//	CREATE TABLE user_greetings (
+//	    user_id            text PRIMARY KEY,
+//	    nickname           text NOT NULL,
+//	    preferred_language text NOT NULL DEFAULT ''
+//	);
+type sqlUserStore struct {
+	db *sql.DB
+}
+
+func (s *sqlUserStore) lookupUser(ctx context.Context, userID string) (userProfile, error) {
+	var p userProfile
+	err := s.db.QueryRowContext(ctx,
+		`SELECT nickname, preferred_language FROM user_greetings WHERE user_id = $1`,
+		userID,
+	).Scan(&p.nickname, &p.language)
+	if errors.Is(err, sql.ErrNoRows) {
+		return userProfile{}, errUserNotFound
+	}
+	return p, err
+}
+
+// breakerUserStore stops calling next while it keeps failing, so an outage
+// costs each request an immediate circuitbreaker.ErrOpen instead of a
+// lookup timeout, and the backend gets room to recover.
+type breakerUserStore struct {
+	next    userStore
+	breaker *circuitbreaker.Breaker
+}
+
+func (s *breakerUserStore) lookupUser(ctx context.Context, userID string) (userProfile, error) {
+	var profile userProfile
+	var lookupErr error
+	err := s.breaker.Do(func() error {
+		profile, lookupErr = s.next.lookupUser(ctx, userID)
+		// Unknown users and callers that gave up say nothing about the
+		// backend's health.
+		if errors.Is(lookupErr, errUserNotFound) || errors.Is(lookupErr, context.Canceled) {
+			return nil
+		}
+		return lookupErr
+	})
+	if err != nil {
+		return userProfile{}, err
+	}
+	return profile, lookupErr
+}
+
+// openUserDB opens a Postgres connection pool and verifies it is reachable.
+func openUserDB(ctx context.Context, dsn string, maxOpenConns int) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxOpenConns)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("ping database: %w", err)
+	}
+	return db, nil
+}
+
+type userIDKey struct{}
+
+// withUserID attaches the caller's user ID to ctx.
+func withUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey{}, userID)
+}
+
+func userIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(userIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// DBGreeter personalizes greetings for known users with the nickname and
+// language stored in the database. Anonymous requests and unknown users get
+// the generic greeting; lookup failures are returned so FallbackGreeter can
+// degrade and count them.
+type DBGreeter struct {
+	store    userStore
+	fallback Greeter
+	// timeout bounds each lookup on top of the request deadline.
+	timeout time.Duration
+	// lookups counts lookups by result (hit, miss, error).
+	lookups *prometheus.CounterVec
+}
+
+func (g *DBGreeter) Greet(ctx context.Context, name, lang string) (string, error) {
+	userID, ok := userIDFromContext(ctx)
+	if !ok {
+		return g.fallback.Greet(ctx, name, lang)
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	profile, err := g.store.lookupUser(lookupCtx, userID)
+	switch {
+	case errors.Is(err, errUserNotFound):
+		g.lookups.WithLabelValues("miss").Inc()
+		return g.fallback.Greet(ctx, name, lang)
+	case err != nil:
+		g.lookups.WithLabelValues("error").Inc()
+		return "", fmt.Errorf("look up user: %w", err)
+	}
+
+	g.lookups.WithLabelValues("hit").Inc()
+	if profile.nickname != "" {
+		name = profile.nickname
+	}
+	if profile.language != "" {
+		lang = profile.language
+	}
+	return g.fallback.Greet(ctx, name, lang)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/example/rest-greeting/internal/circuitbreaker"
+)
+
+// fakeUserStore is an in-memory userStore.
+type fakeUserStore struct {
+	profiles map[string]userProfile
+	// err, when set, fails every lookup.
+	err error
+	// hadDeadline records whether the last lookup was bounded.
+	hadDeadline bool
+	// calls counts lookups that reached the store.
+	calls int
+}
+
+func (s *fakeUserStore) lookupUser(ctx context.Context, userID string) (userProfile, error) {
+	s.calls++
+	_, s.hadDeadline = ctx.Deadline()
+	if s.err != nil {
+		return userProfile{}, s.err
+	}
+	p, ok := s.profiles[userID]
+	if !ok {
+		return userProfile{}, errUserNotFound
+	}
+	return p, nil
+}
+
+// echoLangGreeter greets with the language so tests can see which one the
+// DBGreeter passed on.
+type echoLangGreeter struct{}
+
+func (echoLangGreeter) Greet(_ context.Context, name, lang string) (string, error) {
+	return "Hello " + name + " [" + lang + "]", nil
+}
+
+func newTestDBGreeter(store userStore) *DBGreeter {
+	return &DBGreeter{
+		store:    store,
+		fallback: echoLangGreeter{},
+		timeout:  time.Second,
+		lookups:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "greeting_db_lookups_total"}, []string{"result"}),
+	}
+}
+
+func TestDBGreeter(t *testing.T) {
+	store := &fakeUserStore{profiles: map[string]userProfile{
+		"u1": {nickname: "Ace", language: "fr"},
+		"u2": {nickname: "", language: "de"},
+	}}
+	tests := []struct {
+		name       string
+		userID     string
+		want       string
+		wantResult string // lookups label incremented; "" for none
+	}{
+		{name: "anonymous", want: "Hello World [en]"},
+		{name: "hit", userID: "u1", want: "Hello Ace [fr]", wantResult: "hit"},
+		{name: "hit without nickname", userID: "u2", want: "Hello World [de]", wantResult: "hit"},
+		{name: "miss", userID: "nobody", want: "Hello World [en]", wantResult: "miss"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestDBGreeter(store)
+			ctx := context.Background()
+			if tt.userID != "" {
+				ctx = withUserID(ctx, tt.userID)
+			}
+
+			got, err := g.Greet(ctx, "World", "en")
+			if err != nil {
+				t.Fatalf("Greet: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Greet = %q, want %q", got, tt.want)
+			}
+			for _, result := range []string{"hit", "miss", "error"} {
+				want := 0.0
+				if result == tt.wantResult {
+					want = 1
+				}
+				if got := testutil.ToFloat64(g.lookups.WithLabelValues(result)); got != want {
+					t.Errorf("lookups{result=%q} = %v, want %v", result, got, want)
+				}
+			}
+			if tt.userID != "" && !store.hadDeadline {
+				t.Error("lookup ran without a deadline")
+			}
+		})
+	}
+}
+
+func TestDBGreeterLookupError(t *testing.T) {
+	g := newTestDBGreeter(&fakeUserStore{err: errors.New("connection refused")})
+
+	got, err := g.Greet(withUserID(context.Background(), "u1"), "World", "en")
+	if err == nil {
+		t.Fatalf("Greet = %q, want the lookup error", got)
+	}
+	if got := testutil.ToFloat64(g.lookups.WithLabelValues("error")); got != 1 {
+		t.Errorf(`lookups{result="error"} = %v, want 1`, got)
+	}
+}
+
+// TestPersonalizedResponsesVary checks that cacheable /hello responses vary
+// on the user ID header, so a shared cache never serves one user's nickname
+// to another.
+func TestPersonalizedResponsesVary(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		header      string
+		userID      string
+		wantMessage string
+	}{
+		{name: "personalized", header: "X-User-Id", userID: "u1", wantMessage: "Hello Ace [en]"},
+		{name: "anonymous", header: "X-User-Id", wantMessage: "Hello World [en]"},
+		{name: "custom header", args: []string{"-user-id-header", "X-Account"}, header: "X-Account", userID: "u1", wantMessage: "Hello Ace [en]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, append([]string{"-cache-control", "public, max-age=60"}, tt.args...)...)
+			deps := newTestDeps(cfg)
+			deps.greeter = newTestDBGreeter(&fakeUserStore{profiles: map[string]userProfile{"u1": {nickname: "Ace"}}})
+			app := newServer(cfg, deps)
+
+			req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+			req.Header.Set("Accept-Language", "en")
+			if tt.userID != "" {
+				req.Header.Set(tt.header, tt.userID)
+			}
+			rec := httptest.NewRecorder()
+			app.ServeHTTP(rec, req)
+
+			var resp greetingResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Message != tt.wantMessage {
+				t.Fatalf("body = %s (%v), want message %q", rec.Body, err, tt.wantMessage)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "public, max-age=60" {
+				t.Errorf("Cache-Control = %q", got)
+			}
+			if vary := rec.Header().Values("Vary"); !slices.Contains(vary, tt.header) {
+				t.Errorf("Vary = %q, want it to include %s", vary, tt.header)
+			}
+		})
+	}
+}
+
+func TestBreakerUserStore(t *testing.T) {
+	tests := []struct {
+		name      string
+		storeErr  error
+		wantOpen  bool
+		wantCalls int
+	}{
+		{name: "failures open the breaker", storeErr: errors.New("connection refused"), wantOpen: true, wantCalls: 3},
+		{name: "timeouts open the breaker", storeErr: context.DeadlineExceeded, wantOpen: true, wantCalls: 3},
+		{name: "unknown users are not failures", storeErr: errUserNotFound, wantCalls: 4},
+		{name: "cancelled callers are not failures", storeErr: context.Canceled, wantCalls: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeUserStore{err: tt.storeErr}
+			breaker := circuitbreaker.New("user_store", circuitbreaker.Options{FailureThreshold: 3, ResetTimeout: time.Hour})
+			g := newTestDBGreeter(&breakerUserStore{next: store, breaker: breaker})
+
+			var lastErr error
+			for range 4 {
+				_, lastErr = g.Greet(withUserID(context.Background(), "u1"), "World", "en")
+			}
+
+			if store.calls != tt.wantCalls {
+				t.Errorf("store calls = %d, want %d", store.calls, tt.wantCalls)
+			}
+			if open := breaker.State() == circuitbreaker.Open; open != tt.wantOpen {
+				t.Errorf("breaker open = %v, want %v", open, tt.wantOpen)
+			}
+			if tt.wantOpen && !errors.Is(lastErr, circuitbreaker.ErrOpen) {
+				t.Errorf("Greet error once open = %v, want %v", lastErr, circuitbreaker.ErrOpen)
+			}
+			if !tt.wantOpen && errors.Is(lastErr, circuitbreaker.ErrOpen) {
+				t.Errorf("Greet error = %v, want the breaker to stay closed", lastErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+type heapStats struct {
+	HeapAlloc   uint64 `json:"heap_alloc_bytes"`
+	HeapInuse   uint64 `json:"heap_inuse_bytes"`
+	HeapObjects uint64 `json:"heap_objects"`
+	NumGC       uint32 `json:"num_gc"`
+}
+
+type gcResponse struct {
+	Before heapStats `json:"before"`
+	After  heapStats `json:"after"`
+}
+
+func readHeapStats() heapStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return heapStatsFrom(&m)
+}
+
+func heapStatsFrom(m *runtime.MemStats) heapStats {
+	return heapStats{
+		HeapAlloc:   m.HeapAlloc,
+		HeapInuse:   m.HeapInuse,
+		HeapObjects: m.HeapObjects,
+		NumGC:       m.NumGC,
+	}
+}
+
+// gcHandler forces a garbage collection and reports heap statistics from
+// before and after the collection.
+func gcHandler(w http.ResponseWriter, r *http.Request) {
+	resp := gcResponse{Before: readHeapStats()}
+	runtime.GC()
+	resp.After = readHeapStats()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to encode response")
+	}
+}
+
+type buildInfo struct {
+	GoVersion string `json:"go_version"`
+	Module    string `json:"module"`
+	Version   string `json:"version"`
+	Revision  string `json:"vcs_revision,omitempty"`
+	Time      string `json:"vcs_time,omitempty"`
+	Modified  bool   `json:"vcs_modified,omitempty"`
+}
+
+type runtimeInfo struct {
+	Goroutines    int       `json:"goroutines"`
+	GOMAXPROCS    int       `json:"gomaxprocs"`
+	NumCPU        int       `json:"num_cpu"`
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds float64   `json:"uptime_seconds"`
+	Heap          heapStats `json:"heap"`
+	SysBytes      uint64    `json:"sys_bytes"`
+}
+
+type infoResponse struct {
+	Build   buildInfo   `json:"build"`
+	Runtime runtimeInfo `json:"runtime"`
+}
+
+// readBuildInfo reports the module and VCS metadata the Go toolchain
+// stamped into the binary.
+func readBuildInfo() buildInfo {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return buildInfo{GoVersion: runtime.Version()}
+	}
+	info := buildInfo{GoVersion: bi.GoVersion, Module: bi.Main.Path, Version: bi.Main.Version}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.Time = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+// infoHandler reports build metadata and live runtime statistics in one
+// response. started is the process start time captured in main.
+func infoHandler(started time.Time) http.HandlerFunc {
+	build := readBuildInfo()
+	return func(w http.ResponseWriter, r *http.Request) {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		resp := infoResponse{
+			Build: build,
+			Runtime: runtimeInfo{
+				Goroutines:    runtime.NumGoroutine(),
+				GOMAXPROCS:    runtime.GOMAXPROCS(0),
+				NumCPU:        runtime.NumCPU(),
+				StartedAt:     started.UTC(),
+				UptimeSeconds: time.Since(started).Seconds(),
+				Heap:          heapStatsFrom(&m),
+				SysBytes:      m.Sys,
+			},
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			writeError(w, http.StatusInternalServerError, "internal_error", "failed to encode response")
+		}
+	}
+}
+
+// requireToken rejects requests that do not carry "Authorization: Bearer
+// <token>". An empty token rejects every request rather than disabling the
+// check, so a missing -debug-token can never expose the debug endpoints.
+func requireToken(token string, handler http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(got, want) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "a valid debug token is required")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// metricsJSONHandler serves the gathered metric families as JSON, which is
+// easier to assert on in scripts and tests than the text exposition format.
+// Families are protobuf messages, so each one is encoded with protojson, the
+// canonical JSON mapping, rather than encoding/json.
+func metricsJSONHandler(gatherer prometheus.Gatherer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			log.Printf("debug: failed to gather metrics: %v", err)
+			writeError(w, http.StatusInternalServerError, "internal_error", "failed to gather metrics")
+			return
+		}
+		encoded := make([]json.RawMessage, 0, len(families))
+		for _, family := range families {
+			b, err := protojson.Marshal(family)
+			if err != nil {
+				log.Printf("debug: failed to encode metric family %s: %v", family.GetName(), err)
+				writeError(w, http.StatusInternalServerError, "internal_error", "failed to encode metrics")
+				return
+			}
+			encoded = append(encoded, b)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(encoded); err != nil {
+			log.Printf("debug: failed to encode metrics response: %v", err)
+			writeError(w, http.StatusInternalServerError, "internal_error", "failed to encode response")
+		}
+	}
+}
+
+// sensitiveHeaders are redacted by echoHandler.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+type traceContextInfo struct {
+	TraceID    string `json:"trace_id"`
+	SpanID     string `json:"span_id"`
+	Sampled    bool   `json:"sampled"`
+	TraceState string `json:"tracestate,omitempty"`
+}
+
+type echoResponse struct {
+	Method   string              `json:"method"`
+	Path     string              `json:"path"`
+	Host     string              `json:"host"`
+	Proto    string              `json:"proto"`
+	ClientIP string              `json:"client_ip"`
+	Headers  map[string][]string `json:"headers"`
+	// Trace is the context the configured propagators extracted from the
+	// request headers; nil when none was sent.
+	Trace *traceContextInfo `json:"trace,omitempty"`
+}
+
+// echoHandler reports the request as the server received it, for debugging
+// proxies and trace header propagation.
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	headers := r.Header.Clone()
+	for _, name := range sensitiveHeaders {
+		if values := headers.Values(name); len(values) > 0 {
+			headers[name] = []string{redact(values[0])}
+		}
+	}
+
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+
+	resp := echoResponse{
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Host:     r.Host,
+		Proto:    r.Proto,
+		ClientIP: clientIP,
+		Headers:  headers,
+	}
+	incoming := trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(r.Header)))
+	if incoming.IsValid() {
+		resp.Trace = &traceContextInfo{
+			TraceID:    incoming.TraceID().String(),
+			SpanID:     incoming.SpanID().String(),
+			Sampled:    incoming.IsSampled(),
+			TraceState: incoming.TraceState().String(),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to encode response")
+	}
+}
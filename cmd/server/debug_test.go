@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestDebugInfo(t *testing.T) {
+	started := time.Now().Add(-time.Minute)
+	cfg := parseTestConfig(t, "-enable-debug-endpoints", "-debug-token", testDebugToken)
+	metrics := newMetricsRouter(cfg, prometheus.NewRegistry(), newServer(cfg, newTestDeps(cfg)), started)
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/info", nil)
+	req.Header.Set("Authorization", "Bearer "+testDebugToken)
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var info infoResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("invalid JSON body %q: %v", rec.Body, err)
+	}
+	rt := info.Runtime
+	checks := []struct {
+		field string
+		ok    bool
+	}{
+		{"build.go_version", info.Build.GoVersion != ""},
+		{"runtime.goroutines", rt.Goroutines > 0},
+		{"runtime.gomaxprocs", rt.GOMAXPROCS > 0},
+		{"runtime.num_cpu", rt.NumCPU > 0},
+		{"runtime.started_at", rt.StartedAt.Equal(started.UTC().Round(0))},
+		{"runtime.uptime_seconds", rt.UptimeSeconds >= 60 && rt.UptimeSeconds < 3600},
+		{"runtime.heap.heap_alloc_bytes", rt.Heap.HeapAlloc > 0},
+		{"runtime.heap.heap_inuse_bytes", rt.Heap.HeapInuse >= rt.Heap.HeapAlloc},
+		{"runtime.heap.heap_objects", rt.Heap.HeapObjects > 0},
+		{"runtime.sys_bytes", rt.SysBytes >= rt.Heap.HeapInuse},
+	}
+	for _, c := range checks {
+		if !c.ok {
+			t.Errorf("%s is implausible in %s", c.field, rec.Body)
+		}
+	}
+}
+
+func TestDebugEndpointsGated(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		token      string
+		wantStatus int
+	}{
+		{name: "disabled", wantStatus: http.StatusNotFound},
+		{name: "enabled without credentials", args: []string{"-enable-debug-endpoints", "-debug-token", testDebugToken}, wantStatus: http.StatusUnauthorized},
+		{name: "enabled with wrong token", args: []string{"-enable-debug-endpoints", "-debug-token", testDebugToken}, token: "guess", wantStatus: http.StatusUnauthorized},
+		{name: "enabled with token", args: []string{"-enable-debug-endpoints", "-debug-token", testDebugToken}, token: testDebugToken, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, tt.args...)
+			metrics := newMetricsRouter(cfg, prometheus.NewRegistry(), newServer(cfg, newTestDeps(cfg)), time.Now())
+			req := httptest.NewRequest(http.MethodGet, "/debug/info", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			metrics.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "matching token", token: "s3cret", authorization: "Bearer s3cret", wantStatus: http.StatusOK},
+		{name: "missing header", token: "s3cret", wantStatus: http.StatusUnauthorized},
+		{name: "wrong scheme", token: "s3cret", authorization: "Basic s3cret", wantStatus: http.StatusUnauthorized},
+		{name: "empty token rejects a bare bearer", authorization: "Bearer ", wantStatus: http.StatusUnauthorized},
+		{name: "empty token rejects no header", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/debug/info", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			requireToken(tt.token, ok).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusUnauthorized {
+				return
+			}
+			var body errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Code != "unauthorized" {
+				t.Fatalf("body = %q, want the JSON error envelope with code unauthorized", rec.Body)
+			}
+		})
+	}
+}
+
+func TestMetricsJSON(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	greetings := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "greeting_served_total", Help: "Greetings."}, []string{"language"})
+	greetings.WithLabelValues("en").Add(3)
+	registry.MustRegister(greetings)
+
+	rec := httptest.NewRecorder()
+	metricsJSONHandler(registry).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/metrics.json", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("invalid JSON body %q: %v", rec.Body, err)
+	}
+	if len(raw) != 1 {
+		t.Fatalf("got %d metric families, want 1", len(raw))
+	}
+	var family dto.MetricFamily
+	if err := protojson.Unmarshal(raw[0], &family); err != nil {
+		t.Fatalf("family %s is not protojson: %v", raw[0], err)
+	}
+	if family.GetName() != "greeting_served_total" || family.GetType() != dto.MetricType_COUNTER {
+		t.Fatalf("family = %s %s, want counter greeting_served_total", family.GetName(), family.GetType())
+	}
+	metric := family.GetMetric()[0]
+	if got := metric.GetCounter().GetValue(); got != 3 {
+		t.Errorf("counter value = %v, want 3", got)
+	}
+	if label := metric.GetLabel()[0]; label.GetName() != "language" || label.GetValue() != "en" {
+		t.Errorf("label = %s=%s, want language=en", label.GetName(), label.GetValue())
+	}
+	// protojson uses the proto field names' JSON form, e.g. "type":"COUNTER".
+	var fields map[string]any
+	if err := json.Unmarshal(raw[0], &fields); err != nil || fields["type"] != "COUNTER" {
+		t.Errorf("family JSON %s, want \"type\":\"COUNTER\"", raw[0])
+	}
+}
+
+func TestMetricsJSONGatherError(t *testing.T) {
+	logs := captureLogs(t)
+	failing := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return nil, errors.New("collector for /var/lib/secret failed")
+	})
+
+	rec := httptest.NewRecorder()
+	metricsJSONHandler(failing).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/metrics.json", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	var body errorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON body %q: %v", rec.Body, err)
+	}
+	if body.Error.Code != "internal_error" || body.Error.Message != "failed to gather metrics" {
+		t.Errorf("error = %+v, want internal_error with a generic message", body.Error)
+	}
+	if strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("body %q leaks the gather error", rec.Body)
+	}
+	if !strings.Contains(logs.String(), "collector for /var/lib/secret failed") {
+		t.Errorf("logs = %q, want the gather error logged", logs)
+	}
+}
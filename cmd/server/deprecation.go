@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// deprecatedParams flags requests that still use query parameters marked
+// deprecated with -deprecated-params. They are honored as before; the
+// response gains a Warning header and the usage is counted so the
+// parameter can be removed once traffic on it stops.
+type deprecatedParams struct {
+	// warnings maps each deprecated parameter to its Warning header value.
+	warnings map[string]string
+	usage    *prometheus.CounterVec
+}
+
+func newDeprecatedParams(params []string, usage *prometheus.CounterVec) *deprecatedParams {
+	d := &deprecatedParams{warnings: make(map[string]string, len(params)), usage: usage}
+	for _, param := range params {
+		// 299 is "miscellaneous persistent warning" (RFC 7234).
+		d.warnings[param] = fmt.Sprintf("299 - %q", "query parameter "+param+" is deprecated")
+		usage.WithLabelValues(param)
+	}
+	return d
+}
+
+// wrap adds a Warning header for each deprecated parameter present.
+func (d *deprecatedParams) wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		for param, warning := range d.warnings {
+			if query.Has(param) {
+				w.Header().Add("Warning", warning)
+				d.usage.WithLabelValues(param).Inc()
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
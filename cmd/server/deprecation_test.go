@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDeprecatedParams(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        string
+		wantWarnings []string
+		wantUsage    map[string]float64
+		wantMessage  string
+	}{
+		{
+			name:        "no deprecated params",
+			query:       "",
+			wantUsage:   map[string]float64{"name": 0, "greeting": 0},
+			wantMessage: "Hello World",
+		},
+		{
+			name:         "deprecated param still honored",
+			query:        "name=Ada",
+			wantWarnings: []string{`299 - "query parameter name is deprecated"`},
+			wantUsage:    map[string]float64{"name": 1, "greeting": 0},
+			wantMessage:  "Hello Ada",
+		},
+		{
+			name:         "deprecated param without a value",
+			query:        "greeting",
+			wantWarnings: []string{`299 - "query parameter greeting is deprecated"`},
+			wantUsage:    map[string]float64{"name": 0, "greeting": 1},
+			wantMessage:  "Hello World",
+		},
+		{
+			name:         "several deprecated params",
+			query:        "name=Ada&greeting=hi",
+			wantWarnings: []string{`299 - "query parameter greeting is deprecated"`, `299 - "query parameter name is deprecated"`},
+			wantUsage:    map[string]float64{"name": 1, "greeting": 1},
+			wantMessage:  "Hello Ada",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, "-deprecated-params", "name,greeting")
+			deps := newTestDeps(cfg)
+			usage := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "deprecated_param_usage_total"}, []string{"param"})
+			deps.deprecated = newDeprecatedParams(cfg.deprecatedParams, usage)
+			app := newServer(cfg, deps)
+
+			rec := serve(app, http.MethodGet, "/hello?"+tt.query)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			warnings := rec.Header().Values("Warning")
+			if len(warnings) != len(tt.wantWarnings) {
+				t.Fatalf("Warning = %q, want %q", warnings, tt.wantWarnings)
+			}
+			for _, want := range tt.wantWarnings {
+				if !slices.Contains(warnings, want) {
+					t.Errorf("Warning = %q, missing %q", warnings, want)
+				}
+			}
+			for param, want := range tt.wantUsage {
+				if got := testutil.ToFloat64(usage.WithLabelValues(param)); got != want {
+					t.Errorf("deprecated_param_usage_total{param=%q} = %v, want %v", param, got, want)
+				}
+			}
+			var resp greetingResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("invalid JSON body %q: %v", rec.Body, err)
+			}
+			if resp.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", resp.Message, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Greeter produces the greeting message for a resolved name. lang is the
+// client's preferred BCP 47 language tag, or "" when it expressed none.
+type Greeter interface {
+	Greet(ctx context.Context, name, lang string) (string, error)
+}
+
+// StaticGreeter greets everyone with "Hello <name>" regardless of
+// language.
+type StaticGreeter struct{}
+
+func (StaticGreeter) Greet(_ context.Context, name, _ string) (string, error) {
+	return "Hello " + name, nil
+}
+
+// FallbackGreeter tries primary and degrades to fallback when it fails, so a
+// backend outage costs personalization rather than availability.
+type FallbackGreeter struct {
+	primary  Greeter
+	fallback Greeter
+	// degraded counts greetings served by fallback after primary failed.
+	degraded prometheus.Counter
+}
+
+func (g *FallbackGreeter) Greet(ctx context.Context, name, lang string) (string, error) {
+	message, err := g.primary.Greet(ctx, name, lang)
+	if err == nil {
+		return message, nil
+	}
+	// Once the request itself is cancelled or out of time there is nobody
+	// to degrade for.
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	log.Printf("greeter failed, serving fallback greeting: %v", err)
+	g.degraded.Inc()
+	return g.fallback.Greet(ctx, name, lang)
+}
+
+// greetingLanguages bounds the language label of greeting_served_total.
+// Extend it together with any greeter that localizes messages.
+var greetingLanguages = map[string]bool{
+	"de": true, "en": true, "es": true, "fr": true, "it": true,
+	"ja": true, "nl": true, "pt": true, "zh": true,
+}
+
+// languageLabel maps a requested language tag to its primary subtag when
+// that is in greetingLanguages, "none" when no language was requested, and
+// "other" otherwise.
+func languageLabel(lang string) string {
+	if lang == "" {
+		return "none"
+	}
+	primary, _, _ := strings.Cut(strings.ToLower(lang), "-")
+	if greetingLanguages[primary] {
+		return primary
+	}
+	return "other"
+}
+
+// preferredLanguage returns the highest-weighted language tag from the
+// request's Accept-Language header, or "" if there is none.
+func preferredLanguage(r *http.Request) string {
+	best, bestQ := "", 0.0
+	for _, header := range r.Header.Values("Accept-Language") {
+		for _, part := range strings.Split(header, ",") {
+			tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			if tag == "" || tag == "*" {
+				continue
+			}
+			q := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				parsed, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					continue
+				}
+				q = parsed
+			}
+			if q > bestQ {
+				best, bestQ = tag, q
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestFallbackGreeterDegrades runs the greeter chain main builds for
+// -db-dsn and checks that lookup failures, and only those, degrade to the
+// static greeting.
+func TestFallbackGreeterDegrades(t *testing.T) {
+	tests := []struct {
+		name         string
+		store        *fakeUserStore
+		want         string
+		wantDegraded float64
+	}{
+		{
+			name:  "hit",
+			store: &fakeUserStore{profiles: map[string]userProfile{"u1": {nickname: "Ace"}}},
+			want:  "Hello Ace [en]",
+		},
+		{
+			name:  "miss",
+			store: &fakeUserStore{},
+			want:  "Hello World [en]",
+		},
+		{
+			name:         "lookup error",
+			store:        &fakeUserStore{err: errors.New("connection refused")},
+			want:         "Hello World",
+			wantDegraded: 1,
+		},
+		{
+			name:         "lookup timeout",
+			store:        &fakeUserStore{err: context.DeadlineExceeded},
+			want:         "Hello World",
+			wantDegraded: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			degraded := prometheus.NewCounter(prometheus.CounterOpts{Name: "greeting_degraded_total"})
+			g := &FallbackGreeter{
+				primary: &CoalescingGreeter{
+					next:   newTestDBGreeter(tt.store),
+					shared: prometheus.NewCounter(prometheus.CounterOpts{Name: "singleflight_shared_total"}),
+				},
+				fallback: StaticGreeter{},
+				degraded: degraded,
+			}
+
+			got, err := g.Greet(withUserID(context.Background(), "u1"), "World", "en")
+			if err != nil {
+				t.Fatalf("Greet: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Greet = %q, want %q", got, tt.want)
+			}
+			if got := testutil.ToFloat64(degraded); got != tt.wantDegraded {
+				t.Errorf("greeting_degraded_total = %v, want %v", got, tt.wantDegraded)
+			}
+		})
+	}
+}
+
+func TestFallbackGreeterRequestDone(t *testing.T) {
+	degraded := prometheus.NewCounter(prometheus.CounterOpts{Name: "greeting_degraded_total"})
+	g := &FallbackGreeter{
+		primary:  newTestDBGreeter(&fakeUserStore{err: context.Canceled}),
+		fallback: StaticGreeter{},
+		degraded: degraded,
+	}
+	ctx, cancel := context.WithCancel(withUserID(context.Background(), "u1"))
+	cancel()
+
+	if _, err := g.Greet(ctx, "World", "en"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Greet error = %v, want %v", err, context.Canceled)
+	}
+	if got := testutil.ToFloat64(degraded); got != 0 {
+		t.Errorf("greeting_degraded_total = %v, want 0", got)
+	}
+}
+
+func TestGreetingSuffix(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		greeter Greeter
+		target  string
+		// want is the message, or for errors the error message, which must
+		// never carry the suffix.
+		wantStatus int
+		want       string
+	}{
+		{name: "emoji suffix", args: []string{"-greeting-suffix", " 👋"}, target: "/hello?name=Ada", wantStatus: http.StatusOK, want: "Hello Ada 👋"},
+		{name: "punctuation", args: []string{"-greeting-suffix", "!"}, target: "/hello", wantStatus: http.StatusOK, want: "Hello World!"},
+		{name: "vendor media type", args: []string{"-greeting-suffix", " 👋", "-json-content-type", "application/vnd.greeting+json"}, target: "/hello?name=Ada", wantStatus: http.StatusOK, want: "Hello Ada 👋"},
+		{name: "off", target: "/hello?name=Ada", wantStatus: http.StatusOK, want: "Hello Ada"},
+		{name: "greeter error", args: []string{"-greeting-suffix", " 👋"}, greeter: errGreeter{err: errors.New("boom")}, target: "/hello?name=Ada", wantStatus: http.StatusInternalServerError, want: "failed to produce a greeting"},
+		{name: "missing name", args: []string{"-greeting-suffix", " 👋", "-require-name"}, target: "/hello", wantStatus: http.StatusBadRequest, want: "a name in the query, the path or the X-Greeting-Name header is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, tt.args...)
+			deps := newTestDeps(cfg)
+			if tt.greeter != nil {
+				deps.greeter = tt.greeter
+			}
+			rec := serve(newServer(cfg, deps), http.MethodGet, tt.target)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var body struct {
+				Message string    `json:"message"`
+				Error   errorBody `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON body %q: %v", rec.Body, err)
+			}
+			got := body.Message
+			if tt.wantStatus != http.StatusOK {
+				got = body.Error.Message
+			}
+			if got != tt.want {
+				t.Errorf("message = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// healthChecker serves the liveness and readiness probes.
+//
+// Liveness (/healthz) answers "should this process be restarted?" and only
+// fails for problems a restart could fix. Readiness (/readyz) answers
+// "should this replica receive traffic?" and also reports degraded
+// dependencies.
+type healthChecker struct {
+	tracing         *exportMonitor
+	tracingRequired bool
+	// offers are the probe formats in preference order, see healthOffers.
+	offers []string
+}
+
+func (h *healthChecker) liveness(w http.ResponseWriter, r *http.Request) {
+	resp := healthResponse{Status: "ok"}
+	status := http.StatusOK
+	if h.tracingRequired && !h.tracing.healthy() {
+		resp = healthResponse{Status: "unhealthy", Checks: map[string]string{"tracing": "failing"}}
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, r, h.offers, status, resp)
+}
+
+func (h *healthChecker) readiness(w http.ResponseWriter, r *http.Request) {
+	resp := healthResponse{Status: "ready", Checks: map[string]string{"tracing": "ok"}}
+	status := http.StatusOK
+	if h.tracingRequired && !h.tracing.confirmed.Load() {
+		resp.Status = "not_ready"
+		resp.Checks["tracing"] = "starting"
+		status = http.StatusServiceUnavailable
+	} else if !h.tracing.healthy() {
+		if h.tracingRequired {
+			resp.Status = "not_ready"
+			resp.Checks["tracing"] = "failing"
+			status = http.StatusServiceUnavailable
+		} else {
+			resp.Checks["tracing"] = "degraded"
+		}
+	}
+	writeHealth(w, r, h.offers, status, resp)
+}
+
+// healthCheck is one subsystem reported by /health/detailed. Only failing
+// required checks make the endpoint answer 503.
+type healthCheck struct {
+	name     string
+	required bool
+	check    func(context.Context) error
+}
+
+// healthRegistry collects subsystem checks as dependencies are wired up and
+// runs them in parallel for /health/detailed.
+type healthRegistry struct {
+	// timeout bounds each check; a check still running afterwards is
+	// reported as failing.
+	timeout time.Duration
+	checks  []healthCheck
+}
+
+// register adds a subsystem check. It must be called before serving.
+func (hr *healthRegistry) register(name string, required bool, check func(context.Context) error) {
+	hr.checks = append(hr.checks, healthCheck{name: name, required: required, check: check})
+}
+
+type subsystemStatus struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Required  bool    `json:"required"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type detailedHealthResponse struct {
+	Status string            `json:"status"`
+	Checks []subsystemStatus `json:"checks"`
+}
+
+// run executes every check concurrently and reports them in registration
+// order.
+func (hr *healthRegistry) run(ctx context.Context) []subsystemStatus {
+	results := make([]subsystemStatus, len(hr.checks))
+	var wg sync.WaitGroup
+	for i, hc := range hr.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = hr.runOne(ctx, hc)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (hr *healthRegistry) runOne(ctx context.Context, hc healthCheck) subsystemStatus {
+	ctx, cancel := context.WithTimeout(ctx, hr.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- hc.check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("no result within %s", hr.timeout)
+	}
+
+	status := subsystemStatus{
+		Name:      hc.name,
+		Status:    "ok",
+		Required:  hc.required,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = "failing"
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// detailed serves /health/detailed: "ok" when every check passes,
+// "degraded" (still 200) when only optional checks fail, and "failing"
+// (503) when a required check fails.
+func (hr *healthRegistry) detailed(w http.ResponseWriter, r *http.Request) {
+	resp := detailedHealthResponse{Status: "ok", Checks: hr.run(r.Context())}
+	status := http.StatusOK
+	for _, c := range resp.Checks {
+		switch {
+		case c.Status == "ok":
+		case c.Required:
+			resp.Status = "failing"
+			status = http.StatusServiceUnavailable
+		case resp.Status == "ok":
+			resp.Status = "degraded"
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// healthContentTypes are the probe response formats.
+var healthContentTypes = []string{"application/json", "text/plain"}
+
+// healthOffers orders healthContentTypes with -default-content-type first,
+// so it is served when Accept is absent and wins ties such as */*.
+func healthOffers(defaultType string) []string {
+	offers := []string{defaultType}
+	for _, t := range healthContentTypes {
+		if t != defaultType {
+			offers = append(offers, t)
+		}
+	}
+	return offers
+}
+
+// writeHealth writes resp as JSON, or as a bare status line for simple
+// probes that ask for text/plain. offers come from healthOffers.
+func writeHealth(w http.ResponseWriter, r *http.Request, offers []string, status int, resp healthResponse) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Vary", "Accept")
+
+	if negotiateContentType(r, offers) == "text/plain" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, plainHealthStatus(status)+"\n")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func plainHealthStatus(status int) string {
+	if status == http.StatusOK {
+		return "OK"
+	}
+	return "NOT READY"
+}
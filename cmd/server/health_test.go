@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDefaultContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		defaultType string
+		accept      string
+		wantType    string
+	}{
+		{name: "json default without Accept", defaultType: "application/json", wantType: "application/json"},
+		{name: "json default with any", defaultType: "application/json", accept: "*/*", wantType: "application/json"},
+		{name: "json default with explicit text", defaultType: "application/json", accept: "text/plain", wantType: "text/plain; charset=utf-8"},
+		{name: "text default without Accept", defaultType: "text/plain", wantType: "text/plain; charset=utf-8"},
+		{name: "text default with any", defaultType: "text/plain", accept: "*/*", wantType: "text/plain; charset=utf-8"},
+		{name: "text default with equal weights", defaultType: "text/plain", accept: "application/json, text/plain", wantType: "text/plain; charset=utf-8"},
+		{name: "text default with explicit json", defaultType: "text/plain", accept: "application/json", wantType: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, "-default-content-type", tt.defaultType)
+			app := newServer(cfg, newTestDeps(cfg))
+			metrics := newMetricsRouter(cfg, prometheus.NewRegistry(), app, time.Now())
+
+			for name, handler := range map[string]http.Handler{"application": app, "metrics": metrics} {
+				req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+				if tt.accept != "" {
+					req.Header.Set("Accept", tt.accept)
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				if rec.Code != http.StatusOK {
+					t.Fatalf("%s /healthz: status = %d, want 200", name, rec.Code)
+				}
+				if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+					t.Errorf("%s /healthz: Content-Type = %q, want %q", name, got, tt.wantType)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// listenerOptions tunes the TCP listeners opened by newListener.
+type listenerOptions struct {
+	// keepAlive is the TCP keep-alive period for accepted connections. Zero
+	// keeps the Go default; a negative value disables keep-alives.
+	keepAlive time.Duration
+	// tcpTuning applies the platform socket tuning from tuneSocket.
+	tcpTuning bool
+}
+
+// newListener opens a TCP listener on addr.
+//
+// The accept backlog is not set here: Go sizes it from the kernel's
+// net.core.somaxconn on Linux, so raise that sysctl to enlarge the queue.
+func newListener(ctx context.Context, addr string, opts listenerOptions) (net.Listener, error) {
+	lc := net.ListenConfig{KeepAlive: opts.keepAlive}
+	if opts.tcpTuning {
+		lc.Control = tuneSocket
+	}
+	return lc.Listen(ctx, "tcp", addr)
+}
+
+// proxyProtocolPolicies maps -proxy-protocol modes to how a PROXY header
+// (v1 or v2) is treated. Malformed headers close the connection in either
+// mode.
+var proxyProtocolPolicies = map[string]proxyproto.Policy{
+	// optional uses the header when present, for ports that also take
+	// direct traffic such as kubelet probes.
+	"optional": proxyproto.USE,
+	// required closes connections that do not start with a header.
+	"required": proxyproto.REQUIRE,
+}
+
+// withProxyProtocol wraps l so that RemoteAddr reports the client address
+// carried in the PROXY header instead of the load balancer's.
+func withProxyProtocol(l net.Listener, mode string) net.Listener {
+	policy := proxyProtocolPolicies[mode]
+	return &proxyproto.Listener{
+		Listener: l,
+		ConnPolicy: func(proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+			return policy, nil
+		},
+	}
+}
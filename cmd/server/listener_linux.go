@@ -0,0 +1,26 @@
+package main
+
+import (
+	"syscall"
+)
+
+// tcpTuningSupported reports whether tuneSocket changes anything on this
+// platform.
+const tcpTuningSupported = true
+
+// deferAcceptSeconds is how long the kernel holds a new connection back
+// from accept until the client has sent data.
+const deferAcceptSeconds = 5
+
+// tuneSocket enables TCP_DEFER_ACCEPT so connections that never send a
+// request do not occupy the accept queue or a serving goroutine.
+func tuneSocket(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_DEFER_ACCEPT, deferAcceptSeconds)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"syscall"
+)
+
+// tcpTuningSupported reports whether tuneSocket changes anything on this
+// platform.
+const tcpTuningSupported = false
+
+// tuneSocket is a no-op outside Linux.
+func tuneSocket(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/pires/go-proxyproto"
+)
+
+func TestProxyProtocolClientAddress(t *testing.T) {
+	client := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234}
+	tests := []struct {
+		name string
+		mode string
+		// version is the PROXY header version sent; 0 sends none.
+		version    byte
+		wantServed bool
+		// wantRemote is the client address the handler should see; ""
+		// means the proxy's own address.
+		wantRemote string
+	}{
+		{name: "v1 header", mode: "optional", version: 1, wantServed: true, wantRemote: "203.0.113.7:51234"},
+		{name: "v2 binary header", mode: "optional", version: 2, wantServed: true, wantRemote: "203.0.113.7:51234"},
+		{name: "v2 binary header required", mode: "required", version: 2, wantServed: true, wantRemote: "203.0.113.7:51234"},
+		{name: "no header when optional", mode: "optional", wantServed: true},
+		{name: "no header when required", mode: "required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			seen := make(chan string, 1)
+			handler := accessLog(1, 0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen <- r.RemoteAddr
+			}))
+
+			raw, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := &http.Server{Handler: handler}
+			go func() { _ = srv.Serve(withProxyProtocol(raw, tt.mode)) }()
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", raw.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if tt.version != 0 {
+				header := proxyproto.HeaderProxyFromAddrs(tt.version, client, conn.RemoteAddr())
+				if _, err := header.WriteTo(conn); err != nil {
+					t.Fatalf("write PROXY header: %v", err)
+				}
+			}
+			if _, err := conn.Write([]byte("GET /hello HTTP/1.1\r\nHost: example\r\nConnection: close\r\n\r\n")); err != nil {
+				t.Fatalf("write request: %v", err)
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if !tt.wantServed {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("got status %d, want the connection rejected", resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read response: %v", err)
+			}
+			resp.Body.Close()
+
+			wantRemote := tt.wantRemote
+			if wantRemote == "" {
+				wantRemote = conn.LocalAddr().String()
+			}
+			if got := <-seen; got != wantRemote {
+				t.Errorf("handler RemoteAddr = %q, want %q", got, wantRemote)
+			}
+			var logged any
+			for _, record := range logRecords(t, logs) {
+				if record["msg"] == "http request" {
+					logged = record["remote_addr"]
+				}
+			}
+			if logged != wantRemote {
+				t.Errorf("access log remote_addr = %v, want %q", logged, wantRemote)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// loadShedRetryAfter is the Retry-After hint, in seconds, sent with shed
+// requests.
+const loadShedRetryAfter = "1"
+
+// loadShedder rejects requests while the sampled heap is over a threshold,
+// trading some 503s for not being OOM-killed during a spike.
+type loadShedder struct {
+	// threshold is the heap size in bytes above which requests are shed.
+	threshold uint64
+	shed      prometheus.Counter
+	over      atomic.Bool
+}
+
+func newLoadShedder(limit uint64, percent float64, shed prometheus.Counter) *loadShedder {
+	return &loadShedder{threshold: uint64(float64(limit) * percent / 100), shed: shed}
+}
+
+// sample checks heap usage every interval until ctx is done.
+// runtime.ReadMemStats briefly stops the world, so the interval should stay
+// around a second rather than per request.
+func (s *loadShedder) sample(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		over := m.HeapAlloc > s.threshold
+		if s.over.Swap(over) != over {
+			if over {
+				log.Printf("heap %d bytes is over the load shedding threshold of %d bytes, rejecting new requests", m.HeapAlloc, s.threshold)
+			} else {
+				log.Printf("heap %d bytes is back under the load shedding threshold, accepting requests", m.HeapAlloc)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// wrap answers 503 while the heap is over the threshold.
+func (s *loadShedder) wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.over.Load() {
+			s.shed.Inc()
+			w.Header().Set("Retry-After", loadShedRetryAfter)
+			writeError(w, http.StatusServiceUnavailable, "overloaded", "the server is under memory pressure, retry shortly")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// byteSizeUnits are the suffixes accepted by parseByteSize.
+var byteSizeUnits = []struct {
+	suffix string
+	scale  uint64
+}{
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes such as "512MiB", "2GiB" or a plain byte count.
+func parseByteSize(s string) (uint64, error) {
+	scale := uint64(1)
+	for _, unit := range byteSizeUnits {
+		if trimmed, ok := strings.CutSuffix(s, unit.suffix); ok {
+			s, scale = trimmed, unit.scale
+			break
+		}
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a byte size like 512MiB", s)
+	}
+	if n > math.MaxUint64/scale {
+		return 0, fmt.Errorf("%q overflows a 64-bit byte count", s)
+	}
+	return n * scale, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint64
+		wantErr bool
+	}{
+		{in: "0", want: 0},
+		{in: "1024", want: 1024},
+		{in: "512B", want: 512},
+		{in: "4KiB", want: 4 << 10},
+		{in: "512MiB", want: 512 << 20},
+		{in: "2GiB", want: 2 << 30},
+		{in: " 3 GiB", want: 3 << 30},
+		{in: "17179869183GiB", want: 17179869183 << 30}, // largest GiB count that fits
+		{in: "18446744073709551615", want: 18446744073709551615},
+		{in: "17179869184GiB", wantErr: true}, // 2^64 bytes
+		{in: "18446744073709551615KiB", wantErr: true},
+		{in: "18446744073709551616", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "MiB", wantErr: true},
+		{in: "-1MiB", wantErr: true},
+		{in: "1.5GiB", wantErr: true},
+		{in: "10TB", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseByteSize(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseByteSize(%q) = %d, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("parseByteSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadShedderOverThreshold(t *testing.T) {
+	tests := []struct {
+		name       string
+		over       bool
+		wantStatus int
+		wantShed   float64
+	}{
+		{name: "under threshold", wantStatus: http.StatusOK},
+		{name: "over threshold", over: true, wantStatus: http.StatusServiceUnavailable, wantShed: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, "-memory-limit", "512MiB")
+			shed := prometheus.NewCounter(prometheus.CounterOpts{Name: "load_shed_total"})
+			deps := newTestDeps(cfg)
+			deps.shedder = newLoadShedder(cfg.memoryLimit, cfg.memoryShedPercent, shed)
+			deps.shedder.over.Store(tt.over)
+			app := newServer(cfg, deps)
+
+			rec := serve(app, http.MethodGet, "/hello")
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.over {
+				if got := rec.Header().Get("Retry-After"); got != loadShedRetryAfter {
+					t.Errorf("Retry-After = %q, want %q", got, loadShedRetryAfter)
+				}
+				if !strings.Contains(rec.Body.String(), `"code":"overloaded"`) {
+					t.Errorf("body = %s, want the overloaded error", rec.Body)
+				}
+			}
+			if got := testutil.ToFloat64(shed); got != tt.wantShed {
+				t.Errorf("load_shed_total = %v, want %v", got, tt.wantShed)
+			}
+			// Probes are never shed.
+			if rec := serve(app, http.MethodGet, "/healthz"); rec.Code != http.StatusOK {
+				t.Errorf("/healthz status = %d, want 200", rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// logFile is a log destination file that can be reopened after logrotate
+// has moved it away.
+type logFile struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+func openLogFile(path string) (*logFile, error) {
+	lf := &logFile{path: path}
+	if err := lf.reopen(); err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+func (lf *logFile) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.f.Write(p)
+}
+
+// reopen opens path afresh in append mode and closes the previous file. If
+// opening fails, logging continues to the previous file.
+func (lf *logFile) reopen() error {
+	f, err := os.OpenFile(lf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	lf.mu.Lock()
+	old := lf.f
+	lf.f = f
+	lf.mu.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
+	return nil
+}
+
+// setLogOutput points the standard logger, and with it the default slog
+// handler, at -log-output. It returns the log file when output goes to one,
+// so the caller can reopen it on SIGHUP.
+func setLogOutput(output string) (*logFile, error) {
+	var w io.Writer
+	var lf *logFile
+	switch output {
+	case "stderr":
+		return nil, nil
+	case "stdout":
+		w = os.Stdout
+	default:
+		var err error
+		if lf, err = openLogFile(output); err != nil {
+			return nil, err
+		}
+		w = lf
+	}
+	log.SetOutput(w)
+	return lf, nil
+}
+
+// reopenLogOnHangup reopens lf every time the process receives SIGHUP,
+// which is what logrotate's postrotate step sends.
+func reopenLogOnHangup(lf *logFile) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := lf.reopen(); err != nil {
+			log.Printf("failed to reopen log file, still writing to the old one: %v", err)
+			continue
+		}
+		log.Printf("reopened log file %s", lf.path)
+	}
+}
+
+// serverErrorLog is an http.Server ErrorLog that sends the server's own
+// errors, such as TLS handshake failures and panics outside handlers,
+// through slog at warn level, tagged with the server name.
+func serverErrorLog(name string) *log.Logger {
+	return slog.NewLogLogger(slog.Default().Handler().WithAttrs([]slog.Attr{slog.String("server", name)}), slog.LevelWarn)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServerErrorLogTLSHandshake(t *testing.T) {
+	logs := captureLogs(t)
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.Config.ErrorLog = serverErrorLog("http")
+	srv.StartTLS()
+
+	// A plain-text request on the TLS port fails the handshake.
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_, _ = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example\r\n\r\n")
+	_, _ = io.ReadAll(conn)
+	conn.Close()
+	// Close waits for the connection goroutine, which logs the failure.
+	srv.Close()
+
+	records := logRecords(t, logs)
+	if len(records) != 1 {
+		t.Fatalf("got %d log records, want 1: %v", len(records), records)
+	}
+	record := records[0]
+	if msg, _ := record["msg"].(string); !strings.Contains(msg, "TLS handshake error") {
+		t.Errorf("msg = %q, want a TLS handshake error", msg)
+	}
+	if record["level"] != "WARN" {
+		t.Errorf("level = %v, want WARN", record["level"])
+	}
+	if record["server"] != "http" {
+		t.Errorf("server = %v, want http", record["server"])
+	}
+}
+
+func TestLogFileReopen(t *testing.T) {
+	tests := []struct {
+		name string
+		// rotate moves the live log file away as logrotate would, and
+		// returns where the lines written so far should end up.
+		rotate     func(t *testing.T, path string) string
+		wantReopen bool
+	}{
+		{
+			name: "renamed",
+			rotate: func(t *testing.T, path string) string {
+				if err := os.Rename(path, path+".1"); err != nil {
+					t.Fatal(err)
+				}
+				return path + ".1"
+			},
+			wantReopen: true,
+		},
+		{
+			name: "directory gone",
+			rotate: func(t *testing.T, path string) string {
+				// Reopening fails, so logging stays on the old file,
+				// which was moved along with its directory.
+				moved := filepath.Dir(path) + ".old"
+				if err := os.Rename(filepath.Dir(path), moved); err != nil {
+					t.Fatal(err)
+				}
+				return filepath.Join(moved, filepath.Base(path))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer, flags := log.Writer(), log.Flags()
+			t.Cleanup(func() {
+				log.SetOutput(writer)
+				log.SetFlags(flags)
+			})
+			path := filepath.Join(t.TempDir(), "logs", "server.log")
+			if err := os.Mkdir(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			lf, err := setLogOutput(path)
+			if err != nil {
+				t.Fatalf("setLogOutput: %v", err)
+			}
+			defer func() { _ = lf.f.Close() }()
+			log.Print("before rotation")
+			slog.Info("slog before rotation")
+
+			rotated := tt.rotate(t, path)
+			err = lf.reopen()
+			if (err == nil) != tt.wantReopen {
+				t.Fatalf("reopen error = %v, want success %v", err, tt.wantReopen)
+			}
+			log.Print("after rotation")
+			slog.Info("slog after rotation")
+
+			old := readFile(t, rotated)
+			for _, line := range []string{"before rotation", "slog before rotation"} {
+				if !strings.Contains(old, line) {
+					t.Errorf("rotated file lacks %q:\n%s", line, old)
+				}
+			}
+			if !tt.wantReopen {
+				if !strings.Contains(old, "after rotation") {
+					t.Errorf("old file lacks lines written after a failed reopen:\n%s", old)
+				}
+				return
+			}
+			if strings.Contains(old, "after rotation") {
+				t.Errorf("rotated file got lines written after reopening:\n%s", old)
+			}
+			current := readFile(t, path)
+			for _, line := range []string{"after rotation", "slog after rotation"} {
+				if !strings.Contains(current, line) {
+					t.Errorf("new file lacks %q:\n%s", line, current)
+				}
+			}
+			if strings.Contains(current, "before rotation") {
+				t.Errorf("new file got lines written before rotation:\n%s", current)
+			}
+		})
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(b)
+}
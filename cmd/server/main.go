@@ -1,38 +1,51 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/propagation"
-	"go.opentelemetry.io/otel/sdk/resource"
-	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/example/rest-greeting/internal/circuitbreaker"
 )
 
 type greetingResponse struct {
 	Message string `json:"message"`
+	// Name is the name the greeting was produced for, after transforms
+	// and defaulting, so clients can see how their input was interpreted.
+	Name     string `json:"name,omitempty"`
+	ServedBy string `json:"served_by,omitempty"`
 }
 
 type statusRecorder struct {
 	http.ResponseWriter
 	status int
+	// body, when set, receives a copy of the response body.
+	body *cappedBuffer
 }
 
 func (sr *statusRecorder) WriteHeader(code int) {
@@ -40,144 +53,636 @@ func (sr *statusRecorder) WriteHeader(code int) {
 	sr.ResponseWriter.WriteHeader(code)
 }
 
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	n, err := sr.ResponseWriter.Write(b)
+	if sr.body != nil {
+		_, _ = sr.body.Write(b[:n])
+	}
+	return n, err
+}
+
 const (
 	defaultHTTPAddr    = ":8080"
 	defaultMetricsAddr = ":9092"
-)
 
-func initTracer(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	// statusClientClosedRequest is recorded for requests abandoned by the
+	// client before a response was written, following nginx's 499. It
+	// keeps them out of both the 2xx and the 5xx series.
+	statusClientClosedRequest = 499
 
-	res, err := resource.New(
-		ctx,
-		resource.WithFromEnv(),
-		resource.WithProcess(),
-		resource.WithTelemetrySDK(),
-		resource.WithAttributes(
-			semconv.ServiceNameKey.String("rest-greeting"),
-		),
-	)
+	// greetingNameHeader supplies the name when neither the query string
+	// nor the path has one.
+	greetingNameHeader = "X-Greeting-Name"
+)
 
+func main() {
+	started := time.Now()
+	cfg, err := parseConfig(flag.CommandLine, os.Args[1:])
 	if err != nil {
-		return nil, fmt.Errorf("create resource: %w", err)
-	}
-
-	clientOpts := []otlptracegrpc.Option{}
-	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint == "" {
-		clientOpts = append(clientOpts, otlptracegrpc.WithEndpoint("localhost:4317"))
+		log.Fatalf("invalid configuration: %v", err)
 	}
-	if strings.ToLower(os.Getenv("OTEL_EXPORTER_OTLP_INSECURE")) != "false" {
-		clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+	if cfg.validate {
+		if err := validateRuntime(cfg); err != nil {
+			log.Fatalf("invalid configuration:\n%v", err)
+		}
+		log.Println("configuration is valid")
+		return
 	}
-
-	exporterCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
-	exporter, err := otlptracegrpc.New(exporterCtx, clientOpts...)
+	logOutput, err := setLogOutput(cfg.logOutput)
 	if err != nil {
-		return nil, fmt.Errorf("create otlp exporter: %w", err)
+		log.Fatalf("failed to set up log output: %v", err)
+	}
+	if logOutput != nil {
+		go reopenLogOnHangup(logOutput)
+	}
+	slog.Info("effective configuration", "config", cfg.logValue())
+	if cfg.injectLatency > 0 || cfg.injectLatencyJitter > 0 {
+		slog.Warn("CHAOS: artificial latency is injected into /hello responses", "latency", cfg.injectLatency, "jitter", cfg.injectLatencyJitter)
+	}
+	if cfg.logBodies {
+		slog.Warn("request and response bodies are logged; they may contain personal data", "max_bytes", cfg.logBodyMaxBytes)
+	}
+	if cfg.injectErrorRate > 0 {
+		slog.Warn("CHAOS: /hello fails deliberately with 500 for a fraction of requests; these are not real bugs", "rate", cfg.injectErrorRate, "seed", cfg.injectErrorSeed)
+	}
+	warnIfNoOTLPEndpoint()
+	if !slices.Contains(cfg.propagators, "tracecontext") {
+		slog.Warn("tracecontext propagator is disabled; W3C traceparent and tracestate headers are neither extracted nor forwarded", "propagators", cfg.propagators)
 	}
 
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exporter),
-		sdktrace.WithResource(res),
-	)
-
-	otel.SetTracerProvider(tp)
-	otel.SetTextMapPropagator(
-		propagation.NewCompositeTextMapPropagator(
-			propagation.TraceContext{},
-			propagation.Baggage{},
-		),
-	)
-
-	return tp, nil
-}
+	// Deferred first so it runs last, after the servers have drained and
+	// the telemetry providers have flushed.
+	if cfg.postShutdownDelay > 0 {
+		defer func() {
+			log.Printf("waiting %s before exiting so sidecars can finish draining", cfg.postShutdownDelay)
+			time.Sleep(cfg.postShutdownDelay)
+		}()
+	}
 
-func main() {
-	httpAddr := flag.String("http-addr", defaultHTTPAddr, "HTTP listen address")
-	metricsAddr := flag.String("metrics-addr", defaultMetricsAddr, "Prometheus metrics listen address")
-	flag.Parse()
+	// Resources that must be released after the servers drain register
+	// their cleanup here instead of deferring it.
+	hooks := &shutdownHooks{}
 
-	tp, err := initTracer(context.Background())
+	tp, tracingMonitor, err := initTracer(context.Background(), cfg)
 	if err != nil {
 		log.Fatalf("failed to set up tracing: %v", err)
 	}
-	defer func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := tp.Shutdown(ctx); err != nil {
-			log.Printf("tracer provider shutdown failed: %v", err)
+	hooks.onShutdown("tracer provider", tp.Shutdown)
+
+	if cfg.otelMetrics {
+		mp, err := initMeterProvider(context.Background())
+		if err != nil {
+			log.Fatalf("failed to set up OTLP metrics: %v", err)
 		}
-	}()
+		hooks.onShutdown("meter provider", mp.Shutdown)
+	}
 
-	requestCounter := prometheus.NewCounterVec(
+	if cfg.tracingRequired {
+		probeCtx, stopProbe := context.WithCancel(context.Background())
+		defer stopProbe()
+		go confirmTracing(probeCtx, tp, tracingMonitor, 5*time.Second)
+	}
+
+	metrics := newHTTPMetrics(cfg)
+
+	// Business metrics use the greeting_ prefix to keep them apart from the
+	// http_ transport metrics.
+	greetingsServed := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "http_requests_total",
-			Help: "Total number of HTTP requests processed.",
+			Namespace: cfg.metricsNamespace,
+			Name:      "greeting_served_total",
+			Help:      "Total number of greetings served, by requested primary language; unlisted languages are \"other\", no preference is \"none\".",
 		},
-		[]string{"method", "path", "status"},
+		[]string{"language"},
 	)
-
-	requestDuration := prometheus.NewHistogramVec(
+	messageLength := prometheus.NewHistogram(
 		prometheus.HistogramOpts{
-			Name:    "http_request_duration_seconds",
-			Help:    "Histogram of latencies for HTTP requests.",
-			Buckets: prometheus.DefBuckets,
+			Namespace: cfg.metricsNamespace,
+			Name:      "greeting_message_length_bytes",
+			Help:      "UTF-8 byte length of the greeting messages served, excluding JSON framing.",
+			Buckets:   prometheus.ExponentialBuckets(8, 2, 8),
 		},
-		[]string{"method", "path", "status"},
 	)
 
 	registry := prometheus.NewRegistry()
-	registry.MustRegister(requestCounter)
-	registry.MustRegister(requestDuration)
-	registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	registry.MustRegister(greetingsServed, messageLength)
+	registry.MustRegister(metrics.collectors()...)
+	registry.MustRegister(tracingMonitor.collectors(cfg.metricsNamespace)...)
+	registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{Namespace: cfg.metricsNamespace}))
 	registry.MustRegister(collectors.NewGoCollector())
 
-	mux := http.NewServeMux()
-	mux.Handle("/hello", instrumentHandler("/hello", requestCounter, requestDuration, http.HandlerFunc(helloHandler)))
+	checks := &healthRegistry{timeout: cfg.healthCheckTimeout}
+	checks.register("tracing", cfg.tracingRequired, tracingMonitor.check)
+
+	client := newOutboundClient(cfg.outboundOptions())
+
+	var greeter Greeter = StaticGreeter{}
+	var store userStore
+	switch {
+	case cfg.dbDSN != "":
+		db, err := openUserDB(context.Background(), cfg.dbDSN, cfg.dbMaxOpenConns)
+		if err != nil {
+			log.Fatalf("failed to connect to user database: %v", err)
+		}
+		hooks.onShutdown("user database", func(context.Context) error { return db.Close() })
+		// Lookups fall back to the generic greeting, so the database is
+		// reported but does not fail the aggregate.
+		checks.register("database", false, db.PingContext)
+		store = &sqlUserStore{db: db}
+	case cfg.userServiceURL != "":
+		store = &httpUserStore{client: client, baseURL: cfg.userServiceURL}
+	}
+	if store != nil {
+		breaker := circuitbreaker.New("user_store", circuitbreaker.Options{
+			FailureThreshold: cfg.breakerThreshold,
+			ResetTimeout:     cfg.breakerResetTimeout,
+			OnStateChange: func(from, to circuitbreaker.State) {
+				slog.Warn("user store circuit breaker changed state", "from", from.String(), "to", to.String())
+			},
+		})
+		registry.MustRegister(breaker.StateGauge(cfg.metricsNamespace))
+		store = &breakerUserStore{next: store, breaker: breaker}
+
+		dbLookups := prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: cfg.metricsNamespace,
+				Name:      "greeting_db_lookups_total",
+				Help:      "Total number of user profile lookups by result (hit, miss, error).",
+			},
+			[]string{"result"},
+		)
+		registry.MustRegister(dbLookups)
+
+		greeter = &DBGreeter{
+			store:    store,
+			fallback: greeter,
+			timeout:  cfg.dbQueryTimeout,
+			lookups:  dbLookups,
+		}
+
+		singleflightShared := prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: cfg.metricsNamespace,
+				Name:      "singleflight_shared_total",
+				Help:      "Total number of greetings whose backend lookup was shared with concurrent identical requests.",
+			},
+		)
+		registry.MustRegister(singleflightShared)
+
+		greeter = &CoalescingGreeter{next: greeter, shared: singleflightShared}
+
+		greetingsDegraded := prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: cfg.metricsNamespace,
+				Name:      "greeting_degraded_total",
+				Help:      "Total number of static fallback greetings served because the personalized greeter failed.",
+			},
+		)
+		registry.MustRegister(greetingsDegraded)
+
+		greeter = &FallbackGreeter{primary: greeter, fallback: StaticGreeter{}, degraded: greetingsDegraded}
+	}
+
+	deps := serverDeps{
+		metrics:       metrics,
+		tracing:       tracingMonitor,
+		greeter:       greeter,
+		greetings:     greetingsServed,
+		messageLength: messageLength,
+		client:        client,
+		checks:        checks,
+	}
+	if cfg.injectErrorRate > 0 {
+		injectedErrors := prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: cfg.metricsNamespace,
+				Name:      "injected_errors_total",
+				Help:      "Total number of /hello requests failed deliberately by -inject-error-rate.",
+			},
+		)
+		registry.MustRegister(injectedErrors)
+		deps.faults = newFaultInjector(cfg.injectErrorRate, cfg.injectErrorSeed, injectedErrors)
+	}
+
+	if len(cfg.deprecatedParams) > 0 {
+		deprecatedUsage := prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: cfg.metricsNamespace,
+				Name:      "deprecated_param_usage_total",
+				Help:      "Total number of /hello requests using a query parameter listed in -deprecated-params.",
+			},
+			[]string{"param"},
+		)
+		registry.MustRegister(deprecatedUsage)
+		deps.deprecated = newDeprecatedParams(cfg.deprecatedParams, deprecatedUsage)
+	}
+
+	if cfg.globalRateLimit > 0 {
+		globalThrottled := prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: cfg.metricsNamespace,
+				Name:      "global_throttled_total",
+				Help:      "Total number of requests rejected with 503 by the global rate limit.",
+			},
+		)
+		registry.MustRegister(globalThrottled)
+		deps.limiter = newGlobalLimiter(cfg.globalRateLimit, cfg.globalRateBurst, globalThrottled)
+	}
+
+	if cfg.memoryLimit > 0 {
+		loadShed := prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: cfg.metricsNamespace,
+				Name:      "load_shed_total",
+				Help:      "Total number of requests rejected with 503 because the heap was over the load shedding threshold.",
+			},
+		)
+		registry.MustRegister(loadShed)
+		deps.shedder = newLoadShedder(cfg.memoryLimit, cfg.memoryShedPercent, loadShed)
+		sampleCtx, stopSampling := context.WithCancel(context.Background())
+		defer stopSampling()
+		go deps.shedder.sample(sampleCtx, cfg.memorySampleInterval)
+	}
+
+	app := newServer(cfg, deps)
+	handler := newHandler(cfg, app)
 
 	httpServer := &http.Server{
-		Addr:    *httpAddr,
-		Handler: mux,
+		Addr:        cfg.httpAddr,
+		Handler:     handler,
+		ConnContext: connContext,
+		ErrorLog:    serverErrorLog("http"),
+	}
+	if cfg.connMaxLifetime > 0 {
+		lifetime := &connLifetime{maxLifetime: cfg.connMaxLifetime}
+		httpServer.Handler = lifetime.enforce(handler)
+		httpServer.ConnState = lifetime.connState
 	}
 
 	metricsServer := &http.Server{
-		Addr:    *metricsAddr,
-		Handler: promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
+		Addr:     cfg.metricsAddr,
+		Handler:  serverHeader(cfg.serverHeader, newMetricsRouter(cfg, registry, app, started)),
+		ErrorLog: serverErrorLog("metrics"),
+	}
+
+	if cfg.tcpTuning && !tcpTuningSupported {
+		log.Println("WARNING: -tcp-tuning has no effect on this platform")
+	}
+
+	inherited, err := inheritedListeners()
+	if err != nil {
+		log.Fatalf("failed to use inherited listeners: %v", err)
+	}
+	listeners := make(map[string]net.Listener)
+	listen := func(name, addr string, opts listenerOptions) (net.Listener, error) {
+		if l, ok := inherited[name]; ok {
+			log.Printf("using inherited %s listener", name)
+			listeners[name] = l
+			return l, nil
+		}
+		l, err := newListener(context.Background(), addr, opts)
+		if err != nil {
+			return nil, err
+		}
+		listeners[name] = l
+		return l, nil
+	}
+
+	httpListener, err := listen(httpListenerName, cfg.httpAddr, listenerOptions{
+		keepAlive: cfg.tcpKeepAlive,
+		tcpTuning: cfg.tcpTuning,
+	})
+	if err != nil {
+		log.Fatalf("HTTP listen failed: %v", err)
+	}
+
+	// The PROXY header precedes the TLS handshake, so this wraps the raw
+	// listener; the unwrapped one stays in listeners for hot restart.
+	if cfg.proxyProtocol != "off" {
+		httpListener = withProxyProtocol(httpListener, cfg.proxyProtocol)
+	}
+
+	serve := httpServer.Serve
+	if cfg.tlsCertFile != "" {
+		certs, err := newCertReloader(cfg.tlsCertFile, cfg.tlsKeyFile)
+		if err != nil {
+			log.Fatalf("failed to load TLS certificate: %v", err)
+		}
+		certs.instrument(registry, cfg.metricsNamespace)
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		watchCtx, stopWatch := context.WithCancel(context.Background())
+		defer stopWatch()
+		go certs.watch(watchCtx, hup, cfg.tlsReloadInterval)
+		httpServer.TLSConfig = newTLSConfig(cfg, certs)
+		serve = func(l net.Listener) error { return httpServer.ServeTLS(l, "", "") }
 	}
 
 	go func() {
-		log.Printf("HTTP server listening on %s", *httpAddr)
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("HTTP server listening on %s", httpListener.Addr())
+		if err := serve(httpListener); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("HTTP server failed: %v", err)
 		}
 	}()
 
-	go func() {
-		log.Printf("Prometheus metrics listening on %s", *metricsAddr)
-		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	metricsFailed := func(err error) {
+		if cfg.metricsRequired {
 			log.Fatalf("metrics server failed: %v", err)
 		}
-	}()
+		log.Printf("ERROR: metrics server failed, metrics are unavailable: %v (continuing because -metrics-required=false)", err)
+	}
+
+	if metricsListener, err := listen(metricsListenerName, cfg.metricsAddr, listenerOptions{tcpTuning: cfg.tcpTuning}); err != nil {
+		metricsFailed(err)
+	} else {
+		go func() {
+			log.Printf("Prometheus metrics listening on %s", metricsListener.Addr())
+			if err := metricsServer.Serve(metricsListener); err != nil && err != http.ErrServerClosed {
+				metricsFailed(err)
+			}
+		}()
+	}
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-	<-stop
-	log.Println("received termination signal, shutting down")
+	if cfg.enableHotRestart {
+		signal.Notify(stop, restartSignal)
+	}
+	var sig os.Signal
+	for sig = range stop {
+		if sig != restartSignal {
+			log.Printf("received %s, shutting down", sig)
+			break
+		}
+		child, err := hotRestart(listeners)
+		if err != nil {
+			log.Printf("hot restart failed, continuing to serve: %v", err)
+			continue
+		}
+		log.Printf("hot restart: started new process %d, draining this one", child.Pid)
+		break
+	}
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// SIGTERM is an orchestrator asking for a full drain; SIGINT is usually
+	// Ctrl-C in a terminal, where a quick exit matters more than in-flight
+	// requests, and a second Ctrl-C skips the drain altogether.
+	httpTimeout, metricsTimeout := cfg.httpShutdownTimeout, cfg.metricsShutdownTimeout
+	if sig == syscall.SIGINT {
+		httpTimeout = min(httpTimeout, cfg.interruptShutdownTimeout)
+		metricsTimeout = min(metricsTimeout, cfg.interruptShutdownTimeout)
+		log.Printf("interrupted: draining for at most %s, interrupt again to exit immediately", httpTimeout)
+		go func() {
+			for sig := range stop {
+				if sig == syscall.SIGINT {
+					log.Println("interrupted again, exiting without draining")
+					os.Exit(1)
+				}
+			}
+		}()
+	}
 
-	_ = httpServer.Shutdown(shutdownCtx)
-	_ = metricsServer.Shutdown(shutdownCtx)
+	// Metrics scrapes are cheap to interrupt, so stop that server first and
+	// give in-flight application requests the longer grace period.
+	drainServer("metrics", metricsServer, metricsTimeout, nil)
+	drainServer("HTTP", httpServer, httpTimeout, &metrics.inFlight)
+
+	hooksCtx, cancelHooks := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+	hooks.run(hooksCtx)
+	cancelHooks()
 
 	log.Println("shutdown complete")
 }
 
-func instrumentHandler(path string, counter *prometheus.CounterVec, duration *prometheus.HistogramVec, handler http.Handler) http.Handler {
-	otelHandler := otelhttp.NewHandler(handler, path)
+// drainServer gracefully shuts srv down, closing any connections still
+// open once timeout elapses. When inFlight is non-nil it also waits for
+// the counted requests to finish and logs progress while they do.
+func drainServer(name string, srv *http.Server, timeout time.Duration, inFlight *atomic.Int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	if inFlight != nil {
+		log.Printf("%s server draining %d in-flight requests (deadline %s)", name, inFlight.Load(), timeout)
+		go logDrainProgress(ctx, name, inFlight)
+	}
+	err := srv.Shutdown(ctx)
+	if err == nil && inFlight != nil {
+		// Shutdown only tracks connections; a request on a hijacked
+		// connection is still counted here.
+		err = waitIdle(ctx, inFlight)
+	}
+	if err != nil {
+		if inFlight != nil {
+			log.Printf("%s server did not drain within %s: %v; abandoning %d in-flight requests and closing remaining connections", name, timeout, err, inFlight.Load())
+		} else {
+			log.Printf("%s server did not drain within %s: %v; closing remaining connections", name, timeout, err)
+		}
+		_ = srv.Close()
+		return
+	}
+	log.Printf("%s server drained in %s", name, time.Since(start).Round(time.Millisecond))
+}
+
+// logDrainProgress logs the in-flight request count every second until ctx
+// is done or nothing is left.
+func logDrainProgress(ctx context.Context, name string, inFlight *atomic.Int64) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n := inFlight.Load()
+			if n == 0 {
+				return
+			}
+			log.Printf("%s server still draining: %d requests in flight", name, n)
+		}
+	}
+}
+
+// waitIdle polls until inFlight reaches zero or ctx is done.
+func waitIdle(ctx context.Context, inFlight *atomic.Int64) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for inFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// httpMetrics groups the collectors updated by instrumentHandler.
+type httpMetrics struct {
+	requests          *prometheus.CounterVec
+	duration          prometheus.ObserverVec
+	contentTypes      *prometheus.CounterVec
+	clientDisconnects prometheus.Counter
+	panics            *prometheus.CounterVec
+	// mediaTypes bounds the content_type label to the media types the
+	// service produces; anything else is reported as "other".
+	mediaTypes map[string]bool
+	// inFlight counts requests currently inside instrumentHandler, so
+	// shutdown can wait for them explicitly.
+	inFlight atomic.Int64
+
+	// maxPaths caps the distinct path label values handed out by
+	// pathLabel; 0 is unlimited.
+	maxPaths int
+	pathsMu  sync.Mutex
+	paths    map[string]bool
+}
+
+// newHTTPMetrics creates the http_ collectors for cfg. They are not
+// registered; see collectors.
+func newHTTPMetrics(cfg *config) *httpMetrics {
+	labels := []string{"method", "path", "status"}
+	m := &httpMetrics{
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: cfg.metricsNamespace,
+				Subsystem: cfg.metricsSubsystem,
+				Name:      "http_requests_total",
+				Help:      "Total number of HTTP requests processed.",
+			},
+			labels,
+		),
+		contentTypes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: cfg.metricsNamespace,
+				Subsystem: cfg.metricsSubsystem,
+				Name:      "http_responses_by_content_type_total",
+				Help:      "Total number of HTTP responses by negotiated content type.",
+			},
+			[]string{"path", "content_type"},
+		),
+		clientDisconnects: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: cfg.metricsNamespace,
+				Subsystem: cfg.metricsSubsystem,
+				Name:      "client_disconnect_total",
+				Help:      "Total number of responses abandoned because the client went away.",
+			},
+		),
+		panics: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: cfg.metricsNamespace,
+				Subsystem: cfg.metricsSubsystem,
+				Name:      "http_panics_total",
+				Help:      "Total number of handler panics recovered and answered with 500.",
+			},
+			[]string{"path"},
+		),
+		// A vendor media type is still a content type the service
+		// produces, not "other".
+		mediaTypes: map[string]bool{
+			"application/json": true,
+			"text/plain":       true,
+			cfg.jsonMediaType:  true,
+		},
+		maxPaths: cfg.metricsMaxPaths,
+	}
+
+	switch cfg.latencyMetricType {
+	case "histogram":
+		m.duration = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: cfg.metricsNamespace,
+				Subsystem: cfg.metricsSubsystem,
+				Name:      "http_request_duration_seconds",
+				Help:      "Histogram of latencies for HTTP requests.",
+				Buckets:   prometheus.DefBuckets,
+			},
+			labels,
+		)
+	case "summary":
+		m.duration = prometheus.NewSummaryVec(
+			prometheus.SummaryOpts{
+				Namespace:  cfg.metricsNamespace,
+				Subsystem:  cfg.metricsSubsystem,
+				Name:       "http_request_duration_seconds",
+				Help:       "Summary of latencies for HTTP requests.",
+				Objectives: cfg.summaryObjectives,
+			},
+			labels,
+		)
+	}
+	return m
+}
+
+// collectors returns the collectors to register.
+func (m *httpMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.requests, m.duration, m.contentTypes, m.clientDisconnects, m.panics}
+}
+
+// pathLabel returns path as the metrics label, or otherPath once maxPaths
+// distinct routes hold a label. Labels are assigned when routes are
+// instrumented, so a route table that grows by mistake degrades into
+// "other" instead of exploding series cardinality.
+func (m *httpMetrics) pathLabel(path string) string {
+	m.pathsMu.Lock()
+	defer m.pathsMu.Unlock()
+	if path == otherPath || m.paths[path] {
+		return path
+	}
+	if m.maxPaths > 0 && len(m.paths) >= m.maxPaths {
+		slog.Warn("metrics path label limit reached, counting route as other", "path", path, "limit", m.maxPaths)
+		return otherPath
+	}
+	if m.paths == nil {
+		m.paths = make(map[string]bool)
+	}
+	m.paths[path] = true
+	return path
+}
+
+// parseObjectives parses a "quantile:error,..." list into summary objectives.
+func parseObjectives(spec string) (map[float64]float64, error) {
+	objectives := make(map[float64]float64)
+	for _, pair := range strings.Split(spec, ",") {
+		q, e, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("objective %q: want quantile:error", pair)
+		}
+		quantile, err := strconv.ParseFloat(q, 64)
+		if err != nil || quantile <= 0 || quantile >= 1 {
+			return nil, fmt.Errorf("objective %q: quantile must be in (0, 1)", pair)
+		}
+		absErr, err := strconv.ParseFloat(e, 64)
+		if err != nil || absErr <= 0 || absErr >= 1 {
+			return nil, fmt.Errorf("objective %q: error must be in (0, 1)", pair)
+		}
+		objectives[quantile] = absErr
+	}
+	return objectives, nil
+}
+
+// contentTypeLabel is the content_type label for a Content-Type header.
+func (m *httpMetrics) contentTypeLabel(header string) string {
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil || !m.mediaTypes[mediaType] {
+		return "other"
+	}
+	return mediaType
+}
+
+// instrumentHandler records Prometheus metrics for handler under the given
+// path label and, when traced is set, wraps it in an otelhttp span.
+func instrumentHandler(path string, metrics *httpMetrics, traced bool, handler http.Handler) http.Handler {
+	path = metrics.pathLabel(path)
+	otelHandler := recoverPanics(path, metrics, handler)
+	if traced {
+		otelHandler = otelhttp.NewHandler(otelHandler, path)
+	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics.inFlight.Add(1)
+		defer metrics.inFlight.Add(-1)
+
 		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
 		start := time.Now()
 
@@ -190,25 +695,203 @@ func instrumentHandler(path string, counter *prometheus.CounterVec, duration *pr
 			"path":   path,
 			"status": strconv.Itoa(statusCode),
 		}
-		counter.With(labels).Inc()
-		duration.With(labels).Observe(elapsed)
+		metrics.requests.With(labels).Inc()
+		metrics.duration.With(labels).Observe(elapsed)
+		metrics.contentTypes.WithLabelValues(path, metrics.contentTypeLabel(recorder.Header().Get("Content-Type"))).Inc()
 	})
 }
 
-func helloHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// recoverPanics turns a handler panic into a 500. It is the one place a
+// panic is accounted for: it runs inside the request span, so the span is
+// marked as failed, and the 500 it writes is what instrumentHandler then
+// records in the request metrics.
+func recoverPanics(path string, metrics *httpMetrics, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// Deliberate aborts are net/http's to handle.
+				panic(v)
+			}
+
+			msg := fmt.Sprint(v)
+			stack := string(debug.Stack())
+			metrics.panics.WithLabelValues(path).Inc()
+			span := trace.SpanFromContext(r.Context())
+			span.AddEvent("panic", trace.WithAttributes(
+				attribute.String("exception.message", msg),
+				attribute.String("exception.stacktrace", stack),
+			))
+			span.SetStatus(codes.Error, "panic: "+msg)
+			slog.Error("handler panic", "path", path, "method", r.Method, "request_id", requestIDFromContext(r.Context()), "panic", msg, "stack", stack)
+			writeError(w, http.StatusInternalServerError, "internal_error", "internal server error")
+		}()
+		handler.ServeHTTP(w, r)
+	})
+}
 
-	name := r.URL.Query().Get("name")
+// helloHandler serves the greeting endpoint.
+type helloHandler struct {
+	// greeter produces the greeting message.
+	greeter Greeter
+	// cacheControl is sent as the Cache-Control header on successful
+	// responses when non-empty.
+	cacheControl string
+	// servedBy identifies this replica in verbose responses; empty omits it.
+	servedBy string
+	// echoName reports the resolved name in verbose responses.
+	echoName bool
+	// userIDHeader carries the caller's user ID for personalized greetings.
+	userIDHeader string
+	// suffix is appended to every greeting message, e.g. "!".
+	suffix string
+	// greetAllNames greets every repeated name parameter instead of only
+	// the first one.
+	greetAllNames bool
+	// requireName rejects requests without a name instead of greeting
+	// "World".
+	requireName bool
+	// greetings counts served greetings by requested language.
+	greetings *prometheus.CounterVec
+	// messageLength observes the byte length of each served message.
+	messageLength prometheus.Observer
+	// transforms normalize the resolved name before greeting. A name they
+	// reduce to "" counts as missing.
+	transforms []func(string) string
+	// prettyJSON indents responses for humans reading them with curl.
+	prettyJSON bool
+	// contentType is the -json-content-type header value and mediaType its
+	// bare media type. Clients that only accept application/json still get
+	// that instead of a vendor type.
+	contentType string
+	mediaType   string
+	// disconnects counts responses abandoned by the client.
+	disconnects prometheus.Counter
+}
+
+func (h *helloHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, endResolve := childSpan(r.Context(), "hello.resolve_name")
+	name := transformName(h.resolveName(r), h.transforms)
+	endResolve()
 	if name == "" {
+		if h.requireName {
+			writeError(w, http.StatusBadRequest, "missing_name", "a name in the query, the path or the "+greetingNameHeader+" header is required")
+			return
+		}
 		name = "World"
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	resp := greetingResponse{Message: "Hello " + name}
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
+	ctx := r.Context()
+	if userID := r.Header.Get(h.userIDHeader); userID != "" {
+		ctx = withUserID(ctx, userID)
+	}
+
+	lang := preferredLanguage(r)
+	message, err := h.greeter.Greet(ctx, name, lang)
+	if err != nil {
+		if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+			// The client hung up while the greeter was working; that is
+			// not a server error and there is nobody to answer.
+			h.disconnects.Inc()
+			w.WriteHeader(statusClientClosedRequest)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "greeting_failed", "failed to produce a greeting")
+		return
+	}
+	h.greetings.WithLabelValues(languageLabel(lang)).Inc()
+
+	// Encode into a buffer first so an encoding failure can still be
+	// reported as a 500 instead of a truncated 200.
+	_, endEncode := childSpan(ctx, "hello.encode_response")
+	resp := greetingResponse{Message: message + h.suffix, ServedBy: h.servedBy}
+	if h.echoName {
+		resp.Name = name
+	}
+	h.messageLength.Observe(float64(len(resp.Message)))
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// Names like "A&B" are returned verbatim rather than as \u0026. HTML
+	// escaping only matters if a browser renders the body as HTML, which
+	// the JSON content type and nosniff rule out.
+	enc.SetEscapeHTML(false)
+	if h.prettyJSON {
+		enc.SetIndent("", "  ")
+	}
+	err = enc.Encode(resp)
+	endEncode()
+	if err != nil {
 		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	contentType := h.contentType
+	if h.mediaType != "application/json" {
+		w.Header().Add("Vary", "Accept")
+		if negotiateContentType(r, []string{h.mediaType, "application/json"}) == "application/json" {
+			contentType = "application/json"
+		}
+	}
+	// Both headers can change the body: the name header when the URL has
+	// none, the user ID header through personalization. A cache keyed on
+	// the URL alone would hand one caller's greeting to another.
+	w.Header().Add("Vary", greetingNameHeader)
+	w.Header().Add("Vary", h.userIDHeader)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if h.cacheControl != "" {
+		w.Header().Set("Cache-Control", h.cacheControl)
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		if isClientDisconnect(r, err) {
+			h.disconnects.Inc()
+			return
+		}
+		log.Printf("failed to write /hello response: %v", err)
+	}
+}
+
+// resolveName picks the raw name by precedence: the name query parameter,
+// then the {name} path segment, then the X-Greeting-Name header. All of
+// them go through the same transforms afterwards.
+func (h *helloHandler) resolveName(r *http.Request) string {
+	if name := h.queryName(r.URL.Query()["name"]); name != "" {
+		return name
+	}
+	if name := r.PathValue("name"); name != "" {
+		return name
+	}
+	return r.Header.Get(greetingNameHeader)
+}
+
+// queryName resolves the name from the (possibly repeated) name query
+// parameter, ignoring empty values.
+func (h *helloHandler) queryName(values []string) string {
+	names := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != "" {
+			names = append(names, v)
+		}
+	}
+
+	switch {
+	case len(names) == 0:
+		return ""
+	case !h.greetAllNames || len(names) == 1:
+		return names[0]
+	default:
+		return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
 	}
 }
+
+// isClientDisconnect reports whether err from writing a response means the
+// client has gone away, in which case there is nobody left to tell.
+func isClientDisconnect(r *http.Request, err error) bool {
+	return r.Context().Err() != nil ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
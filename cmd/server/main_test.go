@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// newTestHelloHandler returns a helloHandler with throwaway metrics and
+// the static greeter.
+func newTestHelloHandler() *helloHandler {
+	return &helloHandler{
+		greeter:       StaticGreeter{},
+		userIDHeader:  "X-User-Id",
+		greetings:     prometheus.NewCounterVec(prometheus.CounterOpts{Name: "greetings"}, []string{"language"}),
+		messageLength: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "message_length"}),
+		disconnects:   prometheus.NewCounter(prometheus.CounterOpts{Name: "disconnects"}),
+		contentType:   "application/json",
+		mediaType:     "application/json",
+	}
+}
+
+// captureLogs sends slog and log output to the returned buffer, as JSON
+// lines, for the duration of the test.
+func captureLogs(t testing.TB) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous, writer, flags := slog.Default(), log.Writer(), log.Flags()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetOutput(writer)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+// logRecords decodes the JSON lines captured by captureLogs.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var record map[string]any
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("decode log record: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func FuzzHelloHandler(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"World",
+		"  padded  ",
+		"A&B<script>",
+		"Zo\u00eb",
+		"e\u0301",              // e + combining acute accent
+		"\U0001F44B\U0001F3FD", // waving hand with skin tone modifier
+		"\U0001F468\u200d\U0001F469\u200d\U0001F467", // family ZWJ sequence
+		"\u202eevil", // right-to-left override
+		"\ufeffbom",  // byte order mark
+		"\u65e5\u672c\u8a9e",
+		"\x00",
+		"nul\x00byte",
+		"\x1b[31mred",
+		"tab\tnew\nline\r",
+		"\xff\xfe invalid utf-8",
+		"\xc3",
+		"\xed\xa0\x80", // UTF-8 encoded surrogate
+	} {
+		f.Add(seed)
+	}
+
+	h := newTestHelloHandler()
+	// Every transform is applied so their edge cases are fuzzed too.
+	for _, name := range []string{"trim", "nfc", "stripemoji", "titlecase"} {
+		h.transforms = append(h.transforms, nameTransformsByName[name])
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		for _, requireName := range []bool{false, true} {
+			h.requireName = requireName
+			req := httptest.NewRequest(http.MethodGet, "/hello?"+url.Values{"name": {name}}.Encode(), nil)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			switch rec.Code {
+			case http.StatusOK:
+				var resp greetingResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("name %q: invalid JSON body %q: %v", name, rec.Body, err)
+				}
+				if resp.Message == "" {
+					t.Fatalf("name %q: empty message", name)
+				}
+			case http.StatusBadRequest:
+				if !requireName {
+					t.Fatalf("name %q: 400 without -require-name", name)
+				}
+				if !json.Valid(rec.Body.Bytes()) {
+					t.Fatalf("name %q: invalid JSON error body %q", name, rec.Body)
+				}
+			default:
+				t.Fatalf("name %q: unexpected status %d", name, rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("name %q: Content-Type %q", name, ct)
+			}
+		}
+	})
+}
+
+func TestRepeatedNameParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		query string
+		want  string
+	}{
+		{name: "first wins", query: "name=Ada&name=Grace", want: "Hello Ada"},
+		{name: "empty first value skipped", query: "name=&name=Grace", want: "Hello Grace"},
+		{name: "all empty", query: "name=&name=", want: "Hello World"},
+		{name: "all greets everyone", args: []string{"-multi-name-mode", "all"}, query: "name=Ada&name=Grace&name=Linus", want: "Hello Ada, Grace and Linus"},
+		{name: "all with two", args: []string{"-multi-name-mode", "all"}, query: "name=Ada&name=Grace", want: "Hello Ada and Grace"},
+		{name: "all skips empty values", args: []string{"-multi-name-mode", "all"}, query: "name=&name=Ada&name=&name=Grace", want: "Hello Ada and Grace"},
+		{name: "all with one", args: []string{"-multi-name-mode", "all"}, query: "name=Ada", want: "Hello Ada"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, tt.args...)
+			rec := serve(newServer(cfg, newTestDeps(cfg)), http.MethodGet, "/hello?"+tt.query)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			var resp greetingResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("invalid JSON body %q: %v", rec.Body, err)
+			}
+			if resp.Message != tt.want {
+				t.Errorf("message = %q, want %q", resp.Message, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseNotHTMLEscaped(t *testing.T) {
+	cfg := parseTestConfig(t)
+	rec := serve(newServer(cfg, newTestDeps(cfg)), http.MethodGet, "/hello?name=A%26B%3Ci%3E")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if want := `{"message":"Hello A&B<i>"}` + "\n"; rec.Body.String() != want {
+		t.Fatalf("body = %q, want %q", rec.Body, want)
+	}
+	for _, escaped := range []string{`\u0026`, `\u003c`, `\u003e`} {
+		if strings.Contains(rec.Body.String(), escaped) {
+			t.Errorf("body %q contains %s", rec.Body, escaped)
+		}
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		accept string
+		want   string
+	}{
+		{name: "compact by default", want: `{"message":"Hello Ada"}` + "\n"},
+		{name: "indented", args: []string{"-pretty-json"}, want: "{\n  \"message\": \"Hello Ada\"\n}\n"},
+		{
+			name:   "indented after negotiation",
+			args:   []string{"-pretty-json", "-json-content-type", "application/vnd.greeting+json"},
+			accept: "application/json",
+			want:   "{\n  \"message\": \"Hello Ada\"\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, tt.args...)
+			req := httptest.NewRequest(http.MethodGet, "/hello?name=Ada", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			newServer(cfg, newTestDeps(cfg)).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			if rec.Body.String() != tt.want {
+				t.Fatalf("body = %q, want %q", rec.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerboseResponseEchoesResolvedName(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		query      string
+		wantName   string
+		wantServed bool
+	}{
+		{name: "not verbose", query: "name=Ada"},
+		{name: "untransformed", args: []string{"-verbose-response"}, query: "name=Ada", wantName: "Ada", wantServed: true},
+		{name: "default name", args: []string{"-verbose-response"}, wantName: "World", wantServed: true},
+		{name: "trimmed and titlecased", args: []string{"-verbose-response", "-name-transforms", "trim,titlecase"}, query: "name=%20%20ada%20LOVELACE%20", wantName: "Ada Lovelace", wantServed: true},
+		{name: "normalized", args: []string{"-verbose-response", "-name-transforms", "nfc"}, query: "name=Zoe%CC%88", wantName: "Zoë", wantServed: true},
+		{name: "emoji stripped", args: []string{"-verbose-response", "-name-transforms", "stripemoji,trim"}, query: "name=Ada%20%F0%9F%91%8B", wantName: "Ada", wantServed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, tt.args...)
+			app := newServer(cfg, newTestDeps(cfg))
+
+			rec := serve(app, http.MethodGet, "/hello?"+tt.query)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			var resp greetingResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("invalid JSON body %q: %v", rec.Body, err)
+			}
+			if resp.Name != tt.wantName {
+				t.Errorf("name = %q, want %q", resp.Name, tt.wantName)
+			}
+			if tt.wantName != "" && resp.Message != "Hello "+tt.wantName {
+				t.Errorf("message = %q, want it to greet %q", resp.Message, tt.wantName)
+			}
+			if (resp.ServedBy != "") != tt.wantServed {
+				t.Errorf("served_by = %q, want present = %v", resp.ServedBy, tt.wantServed)
+			}
+		})
+	}
+}
+
+func TestNamePrecedence(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		target      string
+		header      string
+		wantStatus  int
+		wantMessage string
+	}{
+		{name: "query wins over path and header", target: "/hello/Path?name=Query", header: "Header", wantMessage: "Hello Query"},
+		{name: "path wins over header", target: "/hello/Path", header: "Header", wantMessage: "Hello Path"},
+		{name: "empty query falls through to path", target: "/hello/Path?name=", header: "Header", wantMessage: "Hello Path"},
+		{name: "header when URL has no name", target: "/hello", header: "Header", wantMessage: "Hello Header"},
+		{name: "default", target: "/hello", wantMessage: "Hello World"},
+		{name: "escaped path segment", target: "/hello/Ada%20Lovelace", wantMessage: "Hello Ada Lovelace"},
+		{name: "versioned path", args: []string{"-api-prefix", "/v1"}, target: "/v1/hello/Path", header: "Header", wantMessage: "Hello Path"},
+		{name: "header is transformed", args: []string{"-name-transforms", "trim,titlecase"}, target: "/hello", header: "  ada  ", wantMessage: "Hello Ada"},
+		{name: "header transformed to empty is missing", args: []string{"-name-transforms", "trim", "-require-name"}, target: "/hello", header: "   ", wantStatus: http.StatusBadRequest},
+		{name: "path transformed to empty is missing", args: []string{"-name-transforms", "stripemoji", "-require-name"}, target: "/hello/%F0%9F%91%8B", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, tt.args...)
+			app := newServer(cfg, newTestDeps(cfg))
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set(greetingNameHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			app.ServeHTTP(rec, req)
+
+			wantStatus := tt.wantStatus
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			if rec.Code != wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, wantStatus, rec.Body)
+			}
+			if wantStatus != http.StatusOK {
+				return
+			}
+			var resp greetingResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("invalid JSON body %q: %v", rec.Body, err)
+			}
+			if resp.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", resp.Message, tt.wantMessage)
+			}
+			if vary := rec.Header().Values("Vary"); !slices.Contains(vary, greetingNameHeader) {
+				t.Errorf("Vary = %q, want it to include %s", vary, greetingNameHeader)
+			}
+		})
+	}
+}
+
+// TestHeaderNameSanitizedLikeQuery checks that a name from X-Greeting-Name
+// gets exactly the treatment the same name gets as a query parameter.
+func TestHeaderNameSanitizedLikeQuery(t *testing.T) {
+	cfg := parseTestConfig(t, "-name-transforms", "trim,nfc,stripemoji,titlecase", "-verbose-response")
+	app := newServer(cfg, newTestDeps(cfg))
+
+	for _, name := range []string{
+		"  ada LOVELACE  ",
+		"Zoë",
+		"Ada \U0001F44B\U0001F3FD",
+		"A&B<script>",
+		"‮evil",
+		"\xff\xfe invalid utf-8",
+		"\x1b[31mred",
+		strings.Repeat("x", 4096),
+	} {
+		fromQuery := serve(app, http.MethodGet, "/hello?"+url.Values{"name": {name}}.Encode())
+
+		req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		req.Header.Set(greetingNameHeader, name)
+		fromHeader := httptest.NewRecorder()
+		app.ServeHTTP(fromHeader, req)
+
+		if fromHeader.Code != fromQuery.Code || fromHeader.Body.String() != fromQuery.Body.String() {
+			t.Errorf("name %q: header answered %d %s, query answered %d %s",
+				name, fromHeader.Code, fromHeader.Body, fromQuery.Code, fromQuery.Body)
+		}
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   any
+		wantMsg string
+	}{
+		{name: "string", value: "boom", wantMsg: "boom"},
+		{name: "error", value: errors.New("nil map write"), wantMsg: "nil map write"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := newSpanRecorder(t)
+			logs := captureLogs(t)
+			cfg := parseTestConfig(t)
+			metrics := newHTTPMetrics(cfg)
+			handler := instrumentHandler("/boom", metrics, true, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				panic(tt.value)
+			}))
+
+			rec := serve(handler, http.MethodGet, "/boom")
+
+			if rec.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want 500", rec.Code)
+			}
+			var body errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Code != "internal_error" {
+				t.Errorf("body = %s (%v), want the internal_error envelope", rec.Body, err)
+			}
+			if strings.Contains(rec.Body.String(), tt.wantMsg) {
+				t.Errorf("body %s leaks the panic value", rec.Body)
+			}
+			if got := testutil.ToFloat64(metrics.panics.WithLabelValues("/boom")); got != 1 {
+				t.Errorf("http_panics_total = %v, want 1", got)
+			}
+			if got := testutil.ToFloat64(metrics.requests.WithLabelValues(http.MethodGet, "/boom", "500")); got != 1 {
+				t.Errorf(`requests{status="500"} = %v, want 1`, got)
+			}
+
+			spans := recorder.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("spans = %d, want 1", len(spans))
+			}
+			span := spans[0]
+			if span.Status().Code != codes.Error {
+				t.Errorf("span status = %v, want Error", span.Status())
+			}
+			var event *sdktrace.Event
+			for i := range span.Events() {
+				if span.Events()[i].Name == "panic" {
+					event = &span.Events()[i]
+				}
+			}
+			if event == nil {
+				t.Fatalf("span events %v lack a panic event", span.Events())
+			}
+			attrs := attribute.NewSet(event.Attributes...)
+			if v, _ := attrs.Value("exception.message"); v.AsString() != tt.wantMsg {
+				t.Errorf("exception.message = %q, want %q", v.AsString(), tt.wantMsg)
+			}
+			if v, _ := attrs.Value("exception.stacktrace"); !strings.Contains(v.AsString(), "TestRecoverPanics") {
+				t.Errorf("exception.stacktrace does not reach the panicking handler:\n%s", v.AsString())
+			}
+
+			var logged map[string]any
+			for _, record := range logRecords(t, logs) {
+				if record["msg"] == "handler panic" {
+					logged = record
+				}
+			}
+			if logged == nil {
+				t.Fatal("panic was not logged")
+			}
+			if logged["panic"] != tt.wantMsg || logged["path"] != "/boom" {
+				t.Errorf("log record = %v", logged)
+			}
+			if stack, _ := logged["stack"].(string); !strings.Contains(stack, "TestRecoverPanics") {
+				t.Errorf("logged stack does not reach the panicking handler:\n%s", stack)
+			}
+		})
+	}
+}
+
+func TestRecoverPanicsLeavesAbortHandler(t *testing.T) {
+	cfg := parseTestConfig(t)
+	metrics := newHTTPMetrics(cfg)
+	handler := instrumentHandler("/abort", metrics, false, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Fatalf("recovered %v, want http.ErrAbortHandler re-raised", v)
+		}
+		if got := testutil.ToFloat64(metrics.panics.WithLabelValues("/abort")); got != 0 {
+			t.Errorf("http_panics_total = %v, want 0 for a deliberate abort", got)
+		}
+	}()
+	serve(handler, http.MethodGet, "/abort")
+}
+
+// failingWriter fails every body write with err, like a connection whose
+// client has gone away.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	err error
+}
+
+func (w *failingWriter) Write([]byte) (int, error) { return 0, w.err }
+
+// cancellingGreeter cancels the request while producing the greeting, as
+// a client hanging up mid-request would.
+type cancellingGreeter struct {
+	cancel context.CancelFunc
+}
+
+func (g cancellingGreeter) Greet(_ context.Context, name, _ string) (string, error) {
+	g.cancel()
+	return "Hello " + name, nil
+}
+
+func TestClientDisconnect(t *testing.T) {
+	tests := []struct {
+		name           string
+		cancel         bool
+		writeErr       error
+		wantDisconnect bool
+		wantLogged     bool
+	}{
+		{name: "cancelled mid-request", cancel: true, writeErr: errors.New("write on closed connection"), wantDisconnect: true},
+		{name: "cancelled context error", writeErr: fmt.Errorf("write: %w", context.Canceled), wantDisconnect: true},
+		{name: "broken pipe", writeErr: &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, wantDisconnect: true},
+		{name: "connection reset", writeErr: &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.ECONNRESET)}, wantDisconnect: true},
+		{name: "genuine write failure", writeErr: errors.New("disk full"), wantLogged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			cfg := parseTestConfig(t)
+			metrics := newHTTPMetrics(cfg)
+			h := newTestHelloHandler()
+			h.disconnects = metrics.clientDisconnects
+			handler := instrumentHandler("/hello", metrics, false, h)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				h.greeter = cancellingGreeter{cancel: cancel}
+			}
+			req := httptest.NewRequest(http.MethodGet, "/hello", nil).WithContext(ctx)
+			handler.ServeHTTP(&failingWriter{ResponseRecorder: httptest.NewRecorder(), err: tt.writeErr}, req)
+
+			wantDisconnects := 0.0
+			if tt.wantDisconnect {
+				wantDisconnects = 1
+			}
+			if got := testutil.ToFloat64(metrics.clientDisconnects); got != wantDisconnects {
+				t.Errorf("client_disconnect_total = %v, want %v", got, wantDisconnects)
+			}
+			for _, status := range []string{"500", "503"} {
+				if got := testutil.ToFloat64(metrics.requests.WithLabelValues(http.MethodGet, "/hello", status)); got != 0 {
+					t.Errorf(`requests{status=%q} = %v, want 0`, status, got)
+				}
+			}
+			if logged := strings.Contains(logs.String(), "failed to write /hello response"); logged != tt.wantLogged {
+				t.Errorf("write failure logged = %v, want %v:\n%s", logged, tt.wantLogged, logs)
+			}
+		})
+	}
+}
+
+// errGreeter fails every greeting with err, after cancelling the request
+// when cancel is set.
+type errGreeter struct {
+	err    error
+	cancel context.CancelFunc
+}
+
+func (g errGreeter) Greet(context.Context, string, string) (string, error) {
+	if g.cancel != nil {
+		g.cancel()
+	}
+	return "", g.err
+}
+
+func TestGreetingErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		// cancel cancels the request before the greeter returns err.
+		cancel         bool
+		err            error
+		wantStatus     int
+		wantDisconnect bool
+	}{
+		{name: "client cancelled", cancel: true, err: context.Canceled, wantStatus: statusClientClosedRequest, wantDisconnect: true},
+		{name: "client cancelled during lookup", cancel: true, err: fmt.Errorf("look up user: %w", context.Canceled), wantStatus: statusClientClosedRequest, wantDisconnect: true},
+		{name: "backend cancelled on its own", err: context.Canceled, wantStatus: http.StatusInternalServerError},
+		{name: "backend failure", err: errors.New("connection refused"), wantStatus: http.StatusInternalServerError},
+		{name: "deadline", err: context.DeadlineExceeded, wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t)
+			deps := newTestDeps(cfg)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			greeter := errGreeter{err: tt.err}
+			if tt.cancel {
+				greeter.cancel = cancel
+			}
+			deps.greeter = greeter
+			app := newServer(cfg, deps)
+
+			rec := httptest.NewRecorder()
+			app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hello", nil).WithContext(ctx))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantDisconnect && rec.Body.Len() != 0 {
+				t.Errorf("body = %s, want none for a departed client", rec.Body)
+			}
+			wantDisconnects := 0.0
+			if tt.wantDisconnect {
+				wantDisconnects = 1
+			}
+			if got := testutil.ToFloat64(deps.metrics.clientDisconnects); got != wantDisconnects {
+				t.Errorf("client_disconnect_total = %v, want %v", got, wantDisconnects)
+			}
+			want500 := 0.0
+			if tt.wantStatus == http.StatusInternalServerError {
+				want500 = 1
+			}
+			if got := testutil.ToFloat64(deps.metrics.requests.WithLabelValues(http.MethodGet, "/hello", "500")); got != want500 {
+				t.Errorf(`requests{status="500"} = %v, want %v`, got, want500)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// negotiateContentType picks the offer the client prefers according to its
+// Accept header. Offers are listed in server preference order, which breaks
+// ties. It returns the first offer when Accept is absent and "" when none
+// of the offers are acceptable.
+func negotiateContentType(r *http.Request, offers []string) string {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value the Accept header assigns to mediaType,
+// using the most specific matching media range.
+func acceptQuality(accept []string, mediaType string) float64 {
+	offerType, _, _ := strings.Cut(mediaType, "/")
+
+	q, specificity := 0.0, -1
+	for _, header := range accept {
+		for _, part := range strings.Split(header, ",") {
+			mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+
+			var s int
+			switch {
+			case mediaRange == mediaType:
+				s = 2
+			case mediaRange == offerType+"/*":
+				s = 1
+			case mediaRange == "*/*":
+				s = 0
+			default:
+				continue
+			}
+			if s <= specificity {
+				continue
+			}
+
+			specificity, q = s, 1
+			if v, ok := params["q"]; ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+	}
+	return q
+}
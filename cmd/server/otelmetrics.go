@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// otelMetricsInterval is how often accumulated measurements are pushed to
+// the collector.
+const otelMetricsInterval = 15 * time.Second
+
+// initMeterProvider configures the global meter provider with an OTLP/gRPC
+// exporter. otelhttp records request counts and latency through the global
+// provider, so traced routes are exported without extra instruments.
+func initMeterProvider(ctx context.Context) (*sdkmetric.MeterProvider, error) {
+	res, err := newResource(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clientOpts := []otlpmetricgrpc.Option{}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint == "" {
+		clientOpts = append(clientOpts, otlpmetricgrpc.WithEndpoint(defaultOTLPEndpoint))
+	}
+	if strings.ToLower(os.Getenv("OTEL_EXPORTER_OTLP_INSECURE")) != "false" {
+		clientOpts = append(clientOpts, otlpmetricgrpc.WithInsecure())
+	}
+
+	exporterCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	exporter, err := otlpmetricgrpc.New(exporterCtx, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("create otlp metric exporter: %w", err)
+	}
+
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(otelMetricsInterval))),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(mp)
+
+	return mp, nil
+}
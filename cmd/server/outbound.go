@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/httptrace/otelhttptrace"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// outboundOptions tune the outbound client's timeout and connection pool.
+type outboundOptions struct {
+	timeout             time.Duration
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+}
+
+// outboundOptions returns the -outbound-* flag values.
+func (c *config) outboundOptions() outboundOptions {
+	return outboundOptions{
+		timeout:             c.outboundTimeout,
+		maxIdleConns:        c.outboundMaxIdleConns,
+		maxIdleConnsPerHost: c.outboundMaxIdleConnsPerHost,
+		idleConnTimeout:     c.outboundIdleConnTimeout,
+	}
+}
+
+// newOutboundClient returns the HTTP client for calls to downstream
+// services. Each request gets a client span and propagated trace headers,
+// and DNS, connect, TLS and first-byte timings are recorded as events on
+// that span rather than as spans of their own.
+func newOutboundClient(opts outboundOptions) *http.Client {
+	return &http.Client{
+		Timeout: opts.timeout,
+		Transport: otelhttp.NewTransport(newOutboundTransport(opts),
+			otelhttp.WithClientTrace(func(ctx context.Context) *httptrace.ClientTrace {
+				return otelhttptrace.NewClientTrace(ctx, otelhttptrace.WithoutSubSpans())
+			}),
+		),
+	}
+}
+
+// newOutboundTransport returns the pooled base transport wrapped by the
+// outbound client.
+func newOutboundTransport(opts outboundOptions) *http.Transport {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.MaxIdleConns = opts.maxIdleConns
+	base.MaxIdleConnsPerHost = opts.maxIdleConnsPerHost
+	base.IdleConnTimeout = opts.idleConnTimeout
+	return base
+}
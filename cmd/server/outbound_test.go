@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboundTransportSettings(t *testing.T) {
+	tests := []struct {
+		name                string
+		args                []string
+		wantTimeout         time.Duration
+		wantMaxIdle         int
+		wantMaxIdlePerHost  int
+		wantIdleConnTimeout time.Duration
+	}{
+		{
+			name:                "defaults",
+			wantTimeout:         5 * time.Second,
+			wantMaxIdle:         100,
+			wantMaxIdlePerHost:  10,
+			wantIdleConnTimeout: 90 * time.Second,
+		},
+		{
+			name: "custom",
+			args: []string{
+				"-outbound-timeout", "2s",
+				"-outbound-max-idle-conns", "7",
+				"-outbound-max-idle-conns-per-host", "3",
+				"-outbound-idle-conn-timeout", "45s",
+			},
+			wantTimeout:         2 * time.Second,
+			wantMaxIdle:         7,
+			wantMaxIdlePerHost:  3,
+			wantIdleConnTimeout: 45 * time.Second,
+		},
+		{
+			name:                "unlimited idle connections kept indefinitely",
+			args:                []string{"-outbound-max-idle-conns", "0", "-outbound-idle-conn-timeout", "0"},
+			wantTimeout:         5 * time.Second,
+			wantMaxIdlePerHost:  10,
+			wantIdleConnTimeout: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := parseTestConfig(t, tt.args...).outboundOptions()
+
+			if got := newOutboundClient(opts).Timeout; got != tt.wantTimeout {
+				t.Errorf("client Timeout = %s, want %s", got, tt.wantTimeout)
+			}
+			transport := newOutboundTransport(opts)
+			if transport.MaxIdleConns != tt.wantMaxIdle {
+				t.Errorf("MaxIdleConns = %d, want %d", transport.MaxIdleConns, tt.wantMaxIdle)
+			}
+			if transport.MaxIdleConnsPerHost != tt.wantMaxIdlePerHost {
+				t.Errorf("MaxIdleConnsPerHost = %d, want %d", transport.MaxIdleConnsPerHost, tt.wantMaxIdlePerHost)
+			}
+			if transport.IdleConnTimeout != tt.wantIdleConnTimeout {
+				t.Errorf("IdleConnTimeout = %s, want %s", transport.IdleConnTimeout, tt.wantIdleConnTimeout)
+			}
+		})
+	}
+}
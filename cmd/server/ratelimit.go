@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// globalLimiter caps the aggregate request rate across all clients with a
+// single token bucket.
+type globalLimiter struct {
+	limiter   *rate.Limiter
+	throttled prometheus.Counter
+	// retryAfter is the Retry-After hint in whole seconds: the time until
+	// the next token, rounded up.
+	retryAfter string
+}
+
+func newGlobalLimiter(perSecond float64, burst int, throttled prometheus.Counter) *globalLimiter {
+	return &globalLimiter{
+		limiter:    rate.NewLimiter(rate.Limit(perSecond), burst),
+		throttled:  throttled,
+		retryAfter: strconv.Itoa(int(math.Ceil(1 / perSecond))),
+	}
+}
+
+// wrap answers 503 when the bucket is empty.
+func (l *globalLimiter) wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.limiter.Allow() {
+			l.throttled.Inc()
+			w.Header().Set("Retry-After", l.retryAfter)
+			writeError(w, http.StatusServiceUnavailable, "rate_limited", "the service is over its request rate limit, retry shortly")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGlobalRateLimit(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		requests       int
+		wantServed     int
+		wantRetryAfter string
+	}{
+		{name: "burst defaults to the rate", args: []string{"-global-rate-limit", "3"}, requests: 5, wantServed: 3, wantRetryAfter: "1"},
+		{name: "explicit burst", args: []string{"-global-rate-limit", "1", "-global-rate-burst", "4"}, requests: 6, wantServed: 4, wantRetryAfter: "1"},
+		{name: "slow rate hints a longer wait", args: []string{"-global-rate-limit", "0.1", "-global-rate-burst", "1"}, requests: 3, wantServed: 1, wantRetryAfter: "10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, tt.args...)
+			throttled := prometheus.NewCounter(prometheus.CounterOpts{Name: "global_throttled_total"})
+			deps := newTestDeps(cfg)
+			deps.limiter = newGlobalLimiter(cfg.globalRateLimit, cfg.globalRateBurst, throttled)
+			app := newServer(cfg, deps)
+
+			served := 0
+			for i := range tt.requests {
+				// Every request comes from a different client: the limit
+				// is global, not per IP.
+				req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+				req.RemoteAddr = fmt.Sprintf("203.0.113.%d:1234", i+1)
+				rec := httptest.NewRecorder()
+				app.ServeHTTP(rec, req)
+
+				switch rec.Code {
+				case http.StatusOK:
+					served++
+				case http.StatusServiceUnavailable:
+					if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+						t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+					}
+					if !strings.Contains(rec.Body.String(), `"code":"rate_limited"`) {
+						t.Errorf("body = %s, want the rate_limited error", rec.Body)
+					}
+				default:
+					t.Fatalf("request %d: status = %d", i, rec.Code)
+				}
+			}
+
+			if served != tt.wantServed {
+				t.Errorf("served %d of %d requests, want %d", served, tt.requests, tt.wantServed)
+			}
+			if got := testutil.ToFloat64(throttled); got != float64(tt.requests-tt.wantServed) {
+				t.Errorf("global_throttled_total = %v, want %d", got, tt.requests-tt.wantServed)
+			}
+			// Probes are never limited.
+			if rec := serve(app, http.MethodGet, "/healthz"); rec.Code != http.StatusOK {
+				t.Errorf("/healthz status = %d while throttled, want 200", rec.Code)
+			}
+		})
+	}
+}
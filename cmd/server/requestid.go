@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// maxRequestIDLen bounds accepted incoming request IDs; longer or
+// non-printable values are replaced with a generated one.
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// requestIDFromContext returns the request ID assigned by requestIDs.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDs reads the request ID from header, generating one when the
+// client sent none or an unusable value, and echoes it back under the same
+// header so callers can correlate responses with logs.
+func requestIDs(header string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(header)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(header, id)
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDs(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		header    string // request header carrying the incoming ID
+		incoming  string
+		wantEcho  string // response header expected to carry the ID
+		wantSame  bool   // whether the incoming ID is kept
+		wantOther string // response header that must stay empty
+	}{
+		{name: "default header kept", header: "X-Request-Id", incoming: "abc-123", wantEcho: "X-Request-Id", wantSame: true},
+		{name: "default header generated", wantEcho: "X-Request-Id"},
+		{name: "custom header kept", args: []string{"-request-id-header", "X-Correlation-Id"}, header: "X-Correlation-Id", incoming: "corr-42", wantEcho: "X-Correlation-Id", wantSame: true, wantOther: "X-Request-Id"},
+		{name: "custom header ignores default", args: []string{"-request-id-header", "X-Correlation-Id"}, header: "X-Request-Id", incoming: "abc-123", wantEcho: "X-Correlation-Id", wantOther: "X-Request-Id"},
+		{name: "unusable value replaced", header: "X-Request-Id", incoming: "has space", wantEcho: "X-Request-Id"},
+		{name: "overlong value replaced", header: "X-Request-Id", incoming: strings.Repeat("a", maxRequestIDLen+1), wantEcho: "X-Request-Id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, tt.args...)
+			var seen string
+			handler := requestIDs(cfg.requestIDHeader, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestIDFromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(tt.wantEcho)
+			if got == "" || got != seen {
+				t.Fatalf("%s = %q, handler saw %q; want the same non-empty ID", tt.wantEcho, got, seen)
+			}
+			if (got == tt.incoming) != tt.wantSame {
+				t.Errorf("%s = %q, incoming %q; want kept = %v", tt.wantEcho, got, tt.incoming, tt.wantSame)
+			}
+			if tt.wantOther != "" && rec.Header().Get(tt.wantOther) != "" {
+				t.Errorf("%s unexpectedly set", tt.wantOther)
+			}
+		})
+	}
+}
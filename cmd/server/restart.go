@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Listener names used in LISTEN_FDNAMES when handing listeners to a new
+// process.
+const (
+	httpListenerName    = "http"
+	metricsListenerName = "metrics"
+)
+
+// listenFDsStart is the first inherited file descriptor under the
+// LISTEN_FDS convention (after stdin, stdout and stderr).
+const listenFDsStart = 3
+
+// inheritedListeners returns the listeners passed to this process using
+// the systemd-style LISTEN_FDS/LISTEN_FDNAMES convention, keyed by name.
+// It returns nil when no listeners were passed.
+func inheritedListeners() (map[string]net.Listener, error) {
+	count := os.Getenv("LISTEN_FDS")
+	if count == "" {
+		return nil, nil
+	}
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// Meant for another process.
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", count)
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make(map[string]net.Listener, n)
+	for i := 0; i < n; i++ {
+		name := strconv.Itoa(i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		l, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited listener %q: %w", name, err)
+		}
+		listeners[name] = l
+	}
+
+	for _, key := range []string{"LISTEN_FDS", "LISTEN_FDNAMES", "LISTEN_PID"} {
+		_ = os.Unsetenv(key)
+	}
+	return listeners, nil
+}
+
+// listenEnv returns env without any LISTEN_* variables, followed by the
+// variables describing the listeners named in names.
+func listenEnv(env []string, names []string) []string {
+	out := make([]string, 0, len(env)+2)
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, "LISTEN_") {
+			out = append(out, kv)
+		}
+	}
+	return append(out,
+		"LISTEN_FDS="+strconv.Itoa(len(names)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+	)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// hotRestartSupported reports whether -enable-hot-restart works on this
+// platform.
+const hotRestartSupported = true
+
+// restartSignal triggers a hot restart when -enable-hot-restart is set.
+var restartSignal os.Signal = syscall.SIGUSR2
+
+// hotRestart starts a new copy of this binary that inherits the given
+// listeners, so it can accept connections while this process drains.
+// Listeners are passed in a stable order via ExtraFiles and described with
+// LISTEN_FDS/LISTEN_FDNAMES.
+func hotRestart(listeners map[string]net.Listener) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("locate executable: %w", err)
+	}
+
+	var names []string
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	for _, name := range []string{httpListenerName, metricsListenerName} {
+		l, ok := listeners[name]
+		if !ok {
+			continue
+		}
+		tl, ok := l.(*net.TCPListener)
+		if !ok {
+			return nil, fmt.Errorf("listener %q is %T, not a TCP listener", name, l)
+		}
+		f, err := tl.File()
+		if err != nil {
+			return nil, fmt.Errorf("dup listener %q: %w", name, err)
+		}
+		names = append(names, name)
+		files = append(files, f)
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = listenEnv(os.Environ(), names)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start new process: %w", err)
+	}
+	return cmd.Process, nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// hotRestartSupported reports whether -enable-hot-restart works on this
+// platform.
+const hotRestartSupported = false
+
+// restartSignal is nil: hot restart is only supported on Linux.
+var restartSignal os.Signal
+
+func hotRestart(map[string]net.Listener) (*os.Process, error) {
+	return nil, errors.New("hot restart is only supported on Linux")
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// route describes a registered application endpoint.
+type route struct {
+	Pattern string   `json:"pattern"`
+	Methods []string `json:"methods"`
+}
+
+// router is a ServeMux that remembers the routes registered on it, since
+// ServeMux itself cannot enumerate its patterns.
+type router struct {
+	mux    *http.ServeMux
+	routes []route
+	// trailingSlash is the -trailing-slash-mode for requests like /hello/
+	// whose path without the slash is a registered route.
+	trailingSlash string
+	// redirect answers requests redirected by the "redirect" mode.
+	redirect http.Handler
+	// instrument, when set, wraps every route with metrics and tracing under
+	// the given path label.
+	instrument func(path string, handler http.Handler) http.Handler
+}
+
+func newRouter() *router {
+	return &router{mux: http.NewServeMux(), redirect: http.HandlerFunc(redirectTrailingSlash)}
+}
+
+// handle registers handler for pattern. Requests with a method outside
+// methods are answered with 405 before reaching handler; GET routes also
+// accept HEAD.
+func (rt *router) handle(pattern string, methods []string, handler http.Handler) {
+	if slices.Contains(methods, http.MethodGet) && !slices.Contains(methods, http.MethodHead) {
+		methods = append(slices.Clip(methods), http.MethodHead)
+	}
+	handler = allowMethods(methods, handler)
+	if rt.instrument != nil {
+		handler = rt.instrument(routeLabel(pattern), handler)
+	}
+	rt.mux.Handle(pattern, handler)
+	rt.routes = append(rt.routes, route{Pattern: pattern, Methods: methods})
+}
+
+// routeLabel is the metrics path label for a route pattern.
+func routeLabel(pattern string) string {
+	if pattern == "/{$}" {
+		return "/"
+	}
+	return pattern
+}
+
+// allowMethods answers 405 with an Allow header and the JSON error envelope
+// for methods not in methods.
+func allowMethods(methods []string, handler http.Handler) http.Handler {
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(methods, r.Method) {
+			w.Header().Set("Allow", allow)
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "allowed methods: "+allow)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rt.trailingSlash != "strict" && r.URL.Path != "/" && strings.HasSuffix(r.URL.Path, "/") {
+		if trimmed := strings.TrimSuffix(r.URL.Path, "/"); rt.registered(trimmed) {
+			if rt.trailingSlash == "redirect" {
+				rt.redirect.ServeHTTP(w, r)
+				return
+			}
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path, r2.URL.RawPath = trimmed, ""
+			r = r2
+		}
+	}
+	rt.mux.ServeHTTP(w, r)
+}
+
+// redirectTrailingSlash sends a 308 to the request URL without its trailing
+// slash, keeping the query string.
+func redirectTrailingSlash(w http.ResponseWriter, r *http.Request) {
+	target := *r.URL
+	target.Path, target.RawPath = strings.TrimSuffix(r.URL.Path, "/"), ""
+	http.Redirect(w, r, target.RequestURI(), http.StatusPermanentRedirect)
+}
+
+// registered reports whether path is exactly a registered route pattern.
+func (rt *router) registered(path string) bool {
+	for _, route := range rt.routes {
+		if route.Pattern == path {
+			return true
+		}
+	}
+	return false
+}
+
+// routesHandler lists the registered routes as JSON.
+func (rt *router) routesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rt.routes); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// otherPath is the metrics path label for requests that match no route,
+// keeping arbitrary client paths out of label values.
+const otherPath = "other"
+
+// redirectPath is the metrics path label for trailing-slash redirects.
+const redirectPath = "redirect"
+
+// notFoundHandler answers unknown routes with the JSON error envelope.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "not_found", "no such route")
+}
+
+// hostname returns the host (or pod) name identifying this replica.
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		log.Printf("failed to resolve hostname: %v", err)
+		return "unknown"
+	}
+	return name
+}
+
+// serverDeps are the collaborators newServer wires into the handlers.
+type serverDeps struct {
+	metrics *httpMetrics
+	tracing *exportMonitor
+	// greeter produces the /hello messages.
+	greeter Greeter
+	// greetings counts served greetings by requested language.
+	greetings *prometheus.CounterVec
+	// messageLength observes the byte length of served greeting messages.
+	messageLength prometheus.Observer
+	// client is the traced HTTP client for downstream calls, shared by
+	// greeters and handlers so connections are pooled.
+	client *http.Client
+	// checks are the subsystem checks behind /health/detailed.
+	checks *healthRegistry
+	// shedder rejects /hello under memory pressure; nil disables it.
+	shedder *loadShedder
+	// limiter caps the aggregate /hello rate; nil disables it.
+	limiter *globalLimiter
+	// faults injects chaos-testing errors into /hello; nil disables it.
+	faults *faultInjector
+	// deprecated flags deprecated /hello query parameters; nil disables it.
+	deprecated *deprecatedParams
+}
+
+// newServer builds the application router with every endpoint registered
+// and instrumented.
+func newServer(cfg *config, deps serverDeps) *router {
+	rt := newRouter()
+	rt.trailingSlash = cfg.trailingSlashMode
+	rt.instrument = func(path string, handler http.Handler) http.Handler {
+		return instrumentHandler(path, deps.metrics, !cfg.traceExcludePaths[path], headerAttributes(cfg.traceHeaderAttributes, handler))
+	}
+	if cfg.trailingSlashMode == "redirect" {
+		rt.redirect = rt.instrument(redirectPath, rt.redirect)
+	}
+	health := &healthChecker{
+		tracing:         deps.tracing,
+		tracingRequired: cfg.tracingRequired,
+		offers:          healthOffers(cfg.defaultContentType),
+	}
+
+	rt.handle("/healthz", []string{http.MethodGet}, http.HandlerFunc(health.liveness))
+	rt.handle("/readyz", []string{http.MethodGet}, http.HandlerFunc(health.readiness))
+	rt.handle("/health/detailed", []string{http.MethodGet}, http.HandlerFunc(deps.checks.detailed))
+
+	helloH := &helloHandler{
+		greeter:       deps.greeter,
+		cacheControl:  cfg.cacheControl,
+		suffix:        cfg.greetingSuffix,
+		userIDHeader:  cfg.userIDHeader,
+		requireName:   cfg.requireName,
+		greetAllNames: cfg.multiNameMode == "all",
+		greetings:     deps.greetings,
+		messageLength: deps.messageLength,
+		transforms:    cfg.nameTransforms,
+		prettyJSON:    cfg.prettyJSON,
+		contentType:   cfg.jsonContentType,
+		mediaType:     cfg.jsonMediaType,
+		disconnects:   deps.metrics.clientDisconnects,
+	}
+	if cfg.verboseResponse {
+		helloH.servedBy = hostname()
+		helloH.echoName = true
+	}
+	var hello http.Handler = helloH
+	if deps.deprecated != nil {
+		hello = deps.deprecated.wrap(hello)
+	}
+	if deps.faults != nil {
+		hello = deps.faults.wrap(hello)
+	}
+	if cfg.injectLatency > 0 || cfg.injectLatencyJitter > 0 {
+		hello = injectLatency(cfg.injectLatency, cfg.injectLatencyJitter, hello)
+	}
+	if cfg.tracingRequired {
+		hello = requireTracing(deps.tracing, hello)
+	}
+	// Shedding comes last so a rejected request costs as little as
+	// possible; probes are never shed.
+	if deps.limiter != nil {
+		hello = deps.limiter.wrap(hello)
+	}
+	if deps.shedder != nil {
+		hello = deps.shedder.wrap(hello)
+	}
+	// Versioned routes get their own path label, so per-version traffic can
+	// be told apart from clients still on the unversioned alias.
+	for _, path := range apiPaths(cfg, "/hello") {
+		rt.handle(path, []string{http.MethodGet}, hello)
+	}
+	for _, path := range apiPaths(cfg, "/hello/{name}") {
+		rt.handle(path, []string{http.MethodGet}, hello)
+	}
+
+	// /debug/echo is served here rather than on the metrics listener because
+	// it is only useful for traffic that went through the real proxies.
+	if cfg.enableDebug {
+		rt.handle("/debug/echo", []string{http.MethodGet}, requireToken(cfg.debugToken, http.HandlerFunc(echoHandler)))
+	}
+
+	if cfg.serveUI {
+		// "/{$}" matches the root exactly; everything else under "/" still
+		// falls through to the 404 handler below.
+		rt.handle("/{$}", []string{http.MethodGet}, uiIndex(apiPaths(cfg, "/hello")[0]))
+		rt.handle("/favicon.ico", []string{http.MethodGet}, uiFile("ui/favicon.ico"))
+	}
+
+	// The fallback is not listed in the route registry: it is not an
+	// endpoint, just the answer for everything that is not one.
+	rt.mux.Handle("/", rt.instrument(otherPath, http.HandlerFunc(notFoundHandler)))
+
+	return rt
+}
+
+// newMetricsRouter builds the router for the metrics listener: the
+// Prometheus endpoint, its own /healthz and, with -enable-debug-endpoints,
+// the /debug routes. Its routes are not instrumented, so scrapes and
+// probes stay out of the request metrics.
+func newMetricsRouter(cfg *config, registry *prometheus.Registry, app *router, started time.Time) *router {
+	rt := newRouter()
+	rt.trailingSlash = "strict"
+
+	rt.handle(cfg.metricsPath, []string{http.MethodGet}, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	// /healthz here only says the metrics server is up, so the port can be
+	// probed on its own; application health stays on the HTTP listener.
+	offers := healthOffers(cfg.defaultContentType)
+	rt.handle("/healthz", []string{http.MethodGet}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, r, offers, http.StatusOK, healthResponse{Status: "ok"})
+	}))
+	if cfg.enableDebug {
+		rt.handle("/debug/gc", []string{http.MethodPost}, requireToken(cfg.debugToken, http.HandlerFunc(gcHandler)))
+		rt.handle("/debug/routes", []string{http.MethodGet}, requireToken(cfg.debugToken, http.HandlerFunc(app.routesHandler)))
+		rt.handle("/debug/info", []string{http.MethodGet}, requireToken(cfg.debugToken, infoHandler(started)))
+		rt.handle("/debug/metrics.json", []string{http.MethodGet}, requireToken(cfg.debugToken, metricsJSONHandler(registry)))
+	}
+
+	// A -metrics-path of "/" already answers every path.
+	if cfg.metricsPath != "/" {
+		rt.mux.Handle("/", http.HandlerFunc(notFoundHandler))
+	}
+	return rt
+}
+
+// apiPaths returns the paths an API route is served at under -api-prefix
+// and -unversioned-alias.
+func apiPaths(cfg *config, path string) []string {
+	if cfg.apiPrefix == "" {
+		return []string{path}
+	}
+	paths := []string{cfg.apiPrefix + path}
+	if cfg.unversionedAlias {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// newHandler wraps app in the cross-cutting middleware enabled by flags.
+// The result is everything the HTTP listener serves apart from
+// connection-level handling, so the full stack can be exercised in-process
+// with httptest. Without initTracer the global tracer provider is a no-op.
+func newHandler(cfg *config, app *router) http.Handler {
+	var handler http.Handler = app
+	if cfg.logBodies {
+		handler = logBodies(cfg.logBodyMaxBytes, handler)
+	}
+	if cfg.compression {
+		handler = compressHandler(newEncoderPools(cfg.compressionLevels), handler)
+	}
+	if cfg.accessLog {
+		handler = accessLog(cfg.accessLogSampleRate, cfg.accessLogSampleSeed, handler)
+	}
+	if cfg.traceForceSampling {
+		handler = forceSampling(handler)
+	}
+	handler = requestIDs(cfg.requestIDHeader, handler)
+	return serverHeader(cfg.serverHeader, handler)
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// newTestDeps returns the minimal serverDeps newServer needs: the static
+// greeter, throwaway metrics and a tracing pipeline that never exported.
+func newTestDeps(cfg *config) serverDeps {
+	return serverDeps{
+		metrics:       newHTTPMetrics(cfg),
+		tracing:       &exportMonitor{},
+		greeter:       StaticGreeter{},
+		greetings:     prometheus.NewCounterVec(prometheus.CounterOpts{Name: "greeting_served_total"}, []string{"language"}),
+		messageLength: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "greeting_message_length_bytes"}),
+		checks:        &healthRegistry{timeout: cfg.healthCheckTimeout},
+	}
+}
+
+// serve sends a request for target through h and returns the recorded
+// response.
+func serve(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestTraceExcludePaths(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		target    string
+		wantSpans int // server spans; handlers may add children
+	}{
+		{name: "hello traced by default", target: "/hello", wantSpans: 1},
+		{name: "healthz excluded by default", target: "/healthz", wantSpans: 0},
+		{name: "readyz excluded by default", target: "/readyz", wantSpans: 0},
+		{name: "unknown route traced", target: "/nope", wantSpans: 1},
+		{name: "hello excluded by flag", args: []string{"-trace-exclude-paths", "/hello"}, target: "/hello", wantSpans: 0},
+		{name: "healthz traced when not listed", args: []string{"-trace-exclude-paths", "/hello"}, target: "/healthz", wantSpans: 1},
+		{name: "healthz traced with an empty list", args: []string{"-trace-exclude-paths", ""}, target: "/healthz", wantSpans: 1},
+		{name: "versioned hello traced under a prefix", args: []string{"-api-prefix", "/v1"}, target: "/v1/hello", wantSpans: 1},
+		{name: "healthz excluded under a prefix", args: []string{"-api-prefix", "/v1", "-unversioned-alias=false"}, target: "/healthz", wantSpans: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := newSpanRecorder(t)
+			cfg := parseTestConfig(t, tt.args...)
+			app := newServer(cfg, newTestDeps(cfg))
+
+			serve(app, http.MethodGet, tt.target)
+
+			var got int
+			for _, span := range recorder.Ended() {
+				if span.SpanKind() == trace.SpanKindServer {
+					got++
+				}
+			}
+			if got != tt.wantSpans {
+				t.Fatalf("%s produced %d server spans, want %d", tt.target, got, tt.wantSpans)
+			}
+		})
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	tests := []struct {
+		mode         string
+		target       string
+		wantStatus   int
+		wantLocation string
+		wantPath     string // path label the request is counted under
+	}{
+		{mode: "strict", target: "/hello/", wantStatus: http.StatusNotFound, wantPath: otherPath},
+		{mode: "strict", target: "/hello", wantStatus: http.StatusOK, wantPath: "/hello"},
+		{mode: "redirect", target: "/hello/", wantStatus: http.StatusPermanentRedirect, wantLocation: "/hello", wantPath: redirectPath},
+		{mode: "redirect", target: "/hello/?name=Ada", wantStatus: http.StatusPermanentRedirect, wantLocation: "/hello?name=Ada", wantPath: redirectPath},
+		{mode: "redirect", target: "/nope/", wantStatus: http.StatusNotFound, wantPath: otherPath},
+		{mode: "ignore", target: "/hello/", wantStatus: http.StatusOK, wantPath: "/hello"},
+		{mode: "ignore", target: "/nope/", wantStatus: http.StatusNotFound, wantPath: otherPath},
+		{mode: "ignore", target: "/", wantStatus: http.StatusNotFound, wantPath: otherPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.target, func(t *testing.T) {
+			cfg := parseTestConfig(t, "-trailing-slash-mode", tt.mode)
+			deps := newTestDeps(cfg)
+			app := newServer(cfg, deps)
+
+			rec := serve(app, http.MethodGet, tt.target)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			labels := prometheus.Labels{"method": http.MethodGet, "path": tt.wantPath, "status": strconv.Itoa(tt.wantStatus)}
+			if got := testutil.ToFloat64(deps.metrics.requests.With(labels)); got != 1 {
+				t.Errorf("http_requests_total%v = %v, want 1", labels, got)
+			}
+		})
+	}
+}
+
+// BenchmarkHelloHandler drives /hello through the full in-process stack,
+// newServer and newHandler, with a no-op tracer provider so only our own
+// code is measured:
+//
+//	go test ./cmd/server -run '^$' -bench HelloHandler
+func BenchmarkHelloHandler(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		args []string
+	}{
+		{name: "defaults"},
+		{name: "all middleware", args: []string{"-compression", "-access-log", "-access-log-sample-rate", "0", "-trace-force-sampling"}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			setTracerProvider(b, noop.NewTracerProvider())
+			captureLogs(b)
+			cfg := parseTestConfig(b, bench.args...)
+			handler := newHandler(cfg, newServer(cfg, newTestDeps(cfg)))
+			req := httptest.NewRequest(http.MethodGet, "/hello?name=Ada", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			b.ReportAllocs()
+			for b.Loop() {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				if rec.Code != http.StatusOK {
+					b.Fatalf("status = %d", rec.Code)
+				}
+			}
+		})
+	}
+}
+
+const testDebugToken = "s3cret"
+
+// newTestRouters returns the application and metrics routers with the debug
+// endpoints enabled.
+func newTestRouters(t *testing.T) (app, metrics *router) {
+	t.Helper()
+	cfg := parseTestConfig(t, "-enable-debug-endpoints", "-debug-token", testDebugToken)
+	app = newServer(cfg, newTestDeps(cfg))
+	return app, newMetricsRouter(cfg, prometheus.NewRegistry(), app, time.Now())
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	app, metrics := newTestRouters(t)
+	tests := []struct {
+		name      string
+		handler   http.Handler
+		method    string
+		target    string
+		wantAllow string
+	}{
+		{name: "hello", handler: app, method: http.MethodPost, target: "/hello", wantAllow: "GET, HEAD"},
+		{name: "healthz", handler: app, method: http.MethodDelete, target: "/healthz", wantAllow: "GET, HEAD"},
+		{name: "readyz", handler: app, method: http.MethodPut, target: "/readyz", wantAllow: "GET, HEAD"},
+		{name: "health detailed", handler: app, method: http.MethodPost, target: "/health/detailed", wantAllow: "GET, HEAD"},
+		{name: "debug echo", handler: app, method: http.MethodPost, target: "/debug/echo", wantAllow: "GET, HEAD"},
+		{name: "metrics", handler: metrics, method: http.MethodPost, target: "/metrics", wantAllow: "GET, HEAD"},
+		{name: "metrics healthz", handler: metrics, method: http.MethodPost, target: "/healthz", wantAllow: "GET, HEAD"},
+		{name: "debug gc", handler: metrics, method: http.MethodGet, target: "/debug/gc", wantAllow: "POST"},
+		{name: "debug routes", handler: metrics, method: http.MethodPost, target: "/debug/routes", wantAllow: "GET, HEAD"},
+		{name: "debug info", handler: metrics, method: http.MethodDelete, target: "/debug/info", wantAllow: "GET, HEAD"},
+		{name: "debug metrics.json", handler: metrics, method: http.MethodPost, target: "/debug/metrics.json", wantAllow: "GET, HEAD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.handler, tt.method, tt.target)
+
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("%s %s: status = %d, want 405", tt.method, tt.target, rec.Code)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+			var body errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Code != "method_not_allowed" {
+				t.Errorf("body = %q, want the method_not_allowed JSON error", rec.Body)
+			}
+		})
+	}
+}
+
+func TestMetricsRouter(t *testing.T) {
+	_, metrics := newTestRouters(t)
+	tests := []struct {
+		method     string
+		target     string
+		token      bool
+		wantStatus int
+	}{
+		{method: http.MethodGet, target: "/metrics", wantStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/healthz", wantStatus: http.StatusOK},
+		{method: http.MethodHead, target: "/healthz", wantStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/nope", wantStatus: http.StatusNotFound},
+		{method: http.MethodPost, target: "/debug/gc", wantStatus: http.StatusUnauthorized},
+		{method: http.MethodPost, target: "/debug/gc", token: true, wantStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/debug/routes", token: true, wantStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/debug/info", token: true, wantStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/debug/metrics.json", token: true, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.token {
+				req.Header.Set("Authorization", "Bearer "+testDebugToken)
+			}
+			rec := httptest.NewRecorder()
+			metrics.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestMetricsListener serves the metrics router on a real port and checks
+// the Prometheus endpoint and /healthz answer side by side.
+func TestMetricsListener(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		target   string
+		want     int
+		wantBody string
+	}{
+		{name: "default metrics path", target: "/metrics", want: http.StatusOK, wantBody: "test_scrapes_total"},
+		{name: "healthz", target: "/healthz", want: http.StatusOK, wantBody: `{"status":"ok"}`},
+		{name: "custom metrics path", args: []string{"-metrics-path", "/internal/metrics"}, target: "/internal/metrics", want: http.StatusOK, wantBody: "test_scrapes_total"},
+		{name: "old path after move", args: []string{"-metrics-path", "/internal/metrics"}, target: "/metrics", want: http.StatusNotFound},
+		{name: "healthz with custom path", args: []string{"-metrics-path", "/internal/metrics"}, target: "/healthz", want: http.StatusOK, wantBody: `{"status":"ok"}`},
+		{name: "root", target: "/", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, tt.args...)
+			registry := prometheus.NewRegistry()
+			scrapes := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_scrapes_total"})
+			scrapes.Inc()
+			registry.MustRegister(scrapes)
+			srv := httptest.NewServer(newMetricsRouter(cfg, registry, newServer(cfg, newTestDeps(cfg)), time.Now()))
+			defer srv.Close()
+
+			resp, err := srv.Client().Get(srv.URL + tt.target)
+			if err != nil {
+				t.Fatalf("GET %s: %v", tt.target, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.want {
+				t.Fatalf("GET %s: status = %d, want %d", tt.target, resp.StatusCode, tt.want)
+			}
+			if !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("GET %s: body %q does not contain %q", tt.target, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestVendorContentType(t *testing.T) {
+	const vendor = "application/vnd.greeting.v1+json"
+	tests := []struct {
+		name     string
+		accept   string
+		wantType string
+	}{
+		{name: "no Accept", wantType: vendor},
+		{name: "vendor type", accept: vendor, wantType: vendor},
+		{name: "any type", accept: "*/*", wantType: vendor},
+		{name: "plain JSON only", accept: "application/json", wantType: "application/json"},
+		{name: "vendor preferred", accept: "application/json;q=0.5, " + vendor, wantType: vendor},
+		{name: "plain JSON preferred", accept: vendor + ";q=0.5, application/json", wantType: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, "-json-content-type", vendor)
+			deps := newTestDeps(cfg)
+			app := newServer(cfg, deps)
+			req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			app.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := rec.Header().Get("Vary"); !strings.Contains(got, "Accept") {
+				t.Errorf("Vary = %q, want it to include Accept", got)
+			}
+			// The vendor type gets its own content_type label rather than
+			// "other".
+			if got := testutil.ToFloat64(deps.metrics.contentTypes.WithLabelValues("/hello", tt.wantType)); got != 1 {
+				t.Errorf("content_type=%q count = %v, want 1", tt.wantType, got)
+			}
+		})
+	}
+}
+
+func TestContentTypeLabelDoesNotLeakAcrossConfigs(t *testing.T) {
+	vendor := newHTTPMetrics(parseTestConfig(t, "-json-content-type", "application/vnd.greeting.v1+json"))
+	plain := newHTTPMetrics(parseTestConfig(t))
+
+	if got := vendor.contentTypeLabel("application/vnd.greeting.v1+json"); got != "application/vnd.greeting.v1+json" {
+		t.Errorf("vendor metrics label = %q, want the vendor type", got)
+	}
+	if got := plain.contentTypeLabel("application/vnd.greeting.v1+json"); got != "other" {
+		t.Errorf("plain metrics label = %q, want other", got)
+	}
+}
+
+func TestAPIPrefix(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		target     string
+		wantStatus int
+		wantPath   string
+	}{
+		{name: "no prefix", target: "/hello", wantStatus: http.StatusOK, wantPath: "/hello"},
+		{name: "no prefix versioned", target: "/v1/hello", wantStatus: http.StatusNotFound, wantPath: otherPath},
+		{name: "versioned", args: []string{"-api-prefix", "/v1"}, target: "/v1/hello", wantStatus: http.StatusOK, wantPath: "/v1/hello"},
+		{name: "legacy alias", args: []string{"-api-prefix", "/v1"}, target: "/hello", wantStatus: http.StatusOK, wantPath: "/hello"},
+		{name: "alias disabled", args: []string{"-api-prefix", "/v1", "-unversioned-alias=false"}, target: "/hello", wantStatus: http.StatusNotFound, wantPath: otherPath},
+		{name: "other version", args: []string{"-api-prefix", "/v1"}, target: "/v2/hello", wantStatus: http.StatusNotFound, wantPath: otherPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, tt.args...)
+			deps := newTestDeps(cfg)
+			app := newServer(cfg, deps)
+
+			rec := serve(app, http.MethodGet, tt.target+"?name=Ada")
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			// Each version is monitored under its own path label.
+			labels := prometheus.Labels{"method": http.MethodGet, "path": tt.wantPath, "status": strconv.Itoa(tt.wantStatus)}
+			if got := testutil.ToFloat64(deps.metrics.requests.With(labels)); got != 1 {
+				t.Errorf("http_requests_total%v = %v, want 1", labels, got)
+			}
+		})
+	}
+}
+
+func TestMetricsMaxPaths(t *testing.T) {
+	allOptions := []string{"-serve-ui", "-enable-debug-endpoints", "-debug-token", testDebugToken, "-api-prefix", "/v1", "-trailing-slash-mode", "redirect"}
+	tests := []struct {
+		name     string
+		args     []string
+		target   string
+		wantPath string
+		wantWarn bool
+	}{
+		{name: "default fits every route", args: allOptions, target: "/v1/hello", wantPath: "/v1/hello"},
+		{name: "unlimited", args: append([]string{"-metrics-max-paths", "0"}, allOptions...), target: "/hello", wantPath: "/hello"},
+		// /healthz and /readyz are registered first and take both labels.
+		{name: "cap exceeded", args: []string{"-metrics-max-paths", "2"}, target: "/hello", wantPath: otherPath, wantWarn: true},
+		{name: "within cap", args: []string{"-metrics-max-paths", "2"}, target: "/readyz", wantPath: "/readyz", wantWarn: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			cfg := parseTestConfig(t, tt.args...)
+			deps := newTestDeps(cfg)
+			app := newServer(cfg, deps)
+
+			rec := serve(app, http.MethodGet, tt.target)
+
+			labels := prometheus.Labels{"method": http.MethodGet, "path": tt.wantPath, "status": strconv.Itoa(rec.Code)}
+			if got := testutil.ToFloat64(deps.metrics.requests.With(labels)); got != 1 {
+				t.Errorf("http_requests_total%v = %v, want 1", labels, got)
+			}
+			var warned bool
+			for _, record := range logRecords(t, logs) {
+				warned = warned || record["msg"] == "metrics path label limit reached, counting route as other"
+			}
+			if warned != tt.wantWarn {
+				t.Errorf("limit warning logged = %v, want %v", warned, tt.wantWarn)
+			}
+		})
+	}
+}
+
+func TestUIHelloPath(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "unversioned", want: "/hello"},
+		{name: "api prefix", args: []string{"-api-prefix", "/v1"}, want: "/v1/hello"},
+		{name: "api prefix without alias", args: []string{"-api-prefix", "/api/v2", "-unversioned-alias=false"}, want: "/api/v2/hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, append([]string{"-serve-ui"}, tt.args...)...)
+			app := newServer(cfg, newTestDeps(cfg))
+
+			page := serve(app, http.MethodGet, "/")
+			if page.Code != http.StatusOK || !strings.HasPrefix(page.Header().Get("Content-Type"), "text/html") {
+				t.Fatalf("GET / = %d %q, want the HTML page", page.Code, page.Header().Get("Content-Type"))
+			}
+			if want := `const helloPath = "` + tt.want + `";`; !strings.Contains(page.Body.String(), want) {
+				t.Fatalf("page does not contain %s", want)
+			}
+			if rec := serve(app, http.MethodGet, tt.want+"?name=Ada"); rec.Code != http.StatusOK {
+				t.Errorf("GET %s = %d, want the page to call a served path", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRequireName(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		target      string
+		wantStatus  int
+		wantMessage string
+		wantCode    string
+	}{
+		{name: "default greets World", target: "/hello", wantStatus: http.StatusOK, wantMessage: "Hello World"},
+		{name: "default greets World for an empty name", target: "/hello?name=", wantStatus: http.StatusOK, wantMessage: "Hello World"},
+		{name: "required and missing", args: []string{"-require-name"}, target: "/hello", wantStatus: http.StatusBadRequest, wantCode: "missing_name"},
+		{name: "required and empty", args: []string{"-require-name"}, target: "/hello?name=", wantStatus: http.StatusBadRequest, wantCode: "missing_name"},
+		{name: "required and given", args: []string{"-require-name"}, target: "/hello?name=Ada", wantStatus: http.StatusOK, wantMessage: "Hello Ada"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, tt.args...)
+			rec := serve(newServer(cfg, newTestDeps(cfg)), http.MethodGet, tt.target)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var body struct {
+				Message string    `json:"message"`
+				Error   errorBody `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON body %q: %v", rec.Body, err)
+			}
+			if body.Message != tt.wantMessage || body.Error.Code != tt.wantCode {
+				t.Errorf("body = %q, want message %q and error code %q", rec.Body, tt.wantMessage, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import "net/http"
+
+// serverHeader sets the Server response header to value on every response,
+// or removes it when value is empty, so nothing a handler or library adds
+// reaches clients unless configured.
+func serverHeader(value string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(&serverHeaderWriter{ResponseWriter: w, value: value}, r)
+	})
+}
+
+// serverHeaderWriter applies the Server header when the response headers
+// are committed, after the handler has had its chance to set them.
+type serverHeaderWriter struct {
+	http.ResponseWriter
+	value       string
+	wroteHeader bool
+}
+
+func (s *serverHeaderWriter) WriteHeader(code int) {
+	if !s.wroteHeader && code >= http.StatusOK {
+		s.wroteHeader = true
+		if s.value == "" {
+			s.Header().Del("Server")
+		} else {
+			s.Header().Set("Server", s.value)
+		}
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *serverHeaderWriter) Write(b []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *serverHeaderWriter) Flush() {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *serverHeaderWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
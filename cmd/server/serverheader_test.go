@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestServerHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "custom value", value: "greeting"},
+		{name: "removed", value: ""},
+	}
+	requests := []struct {
+		method string
+		target string
+		status int
+	}{
+		{method: http.MethodGet, target: "/hello", status: http.StatusOK},
+		{method: http.MethodGet, target: "/nope", status: http.StatusNotFound},
+		{method: http.MethodPost, target: "/hello", status: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, target: "/healthz", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want []string
+			if tt.value != "" {
+				want = []string{tt.value}
+			}
+			cfg := parseTestConfig(t, "-server-header", tt.value)
+			handler := newHandler(cfg, newServer(cfg, newTestDeps(cfg)))
+
+			for _, req := range requests {
+				rec := serve(handler, req.method, req.target)
+				if rec.Code != req.status {
+					t.Fatalf("%s %s: status = %d, want %d", req.method, req.target, rec.Code, req.status)
+				}
+				if got := rec.Header().Values("Server"); !slices.Equal(got, want) {
+					t.Errorf("%s %s: Server = %q, want %q", req.method, req.target, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestServerHeaderOverridesHandler(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "leaky/1.0")
+		w.WriteHeader(http.StatusTeapot)
+	})
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "greeting", want: "greeting"},
+		{value: "", want: ""},
+	}
+	for _, tt := range tests {
+		rec := serve(serverHeader(tt.value, inner), http.MethodGet, "/")
+		if got := rec.Header().Get("Server"); got != tt.want {
+			t.Errorf("serverHeader(%q): Server = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// shutdownHooks collects cleanup callbacks for resources that outlive the
+// servers, such as telemetry providers and connection pools, so main can
+// release them in one place once the servers have drained.
+type shutdownHooks struct {
+	mu    sync.Mutex
+	hooks []shutdownHook
+}
+
+type shutdownHook struct {
+	name string
+	fn   func(context.Context) error
+}
+
+// onShutdown registers fn to run during shutdown. Hooks run in reverse
+// registration order, like defers, so a resource is released before the
+// ones it was built on.
+func (s *shutdownHooks) onShutdown(name string, fn func(context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, shutdownHook{name: name, fn: fn})
+}
+
+// run calls every registered hook with ctx, logging failures rather than
+// stopping, so one stuck resource does not leak the others.
+func (s *shutdownHooks) run(ctx context.Context) {
+	s.mu.Lock()
+	hooks := s.hooks
+	s.hooks = nil
+	s.mu.Unlock()
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i].fn(ctx); err != nil {
+			log.Printf("%s shutdown failed: %v", hooks[i].name, err)
+		}
+	}
+}
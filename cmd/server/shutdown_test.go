@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type ctxKey struct{}
+
+func TestShutdownHooks(t *testing.T) {
+	tests := []struct {
+		name      string
+		failing   []string
+		wantOrder []string
+		wantLogs  []string
+	}{
+		{
+			name:      "reverse registration order",
+			wantOrder: []string{"cache", "database", "tracer"},
+		},
+		{
+			name:      "failures are logged and do not stop later hooks",
+			failing:   []string{"cache", "database"},
+			wantOrder: []string{"cache", "database", "tracer"},
+			wantLogs:  []string{"cache shutdown failed: close cache", "database shutdown failed: close database"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			ctx := context.WithValue(context.Background(), ctxKey{}, "shutdown")
+			hooks := &shutdownHooks{}
+			var order []string
+			for _, name := range []string{"tracer", "database", "cache"} {
+				hooks.onShutdown(name, func(ctx context.Context) error {
+					if ctx.Value(ctxKey{}) != "shutdown" {
+						t.Errorf("%s hook did not get the shutdown context", name)
+					}
+					order = append(order, name)
+					if slices.Contains(tt.failing, name) {
+						return errors.New("close " + name)
+					}
+					return nil
+				})
+			}
+
+			hooks.run(ctx)
+			// Hooks run once; a second drain is a no-op.
+			hooks.run(ctx)
+
+			if !slices.Equal(order, tt.wantOrder) {
+				t.Errorf("hooks ran in order %v, want %v", order, tt.wantOrder)
+			}
+			var got []string
+			for _, record := range logRecords(t, logs) {
+				got = append(got, record["msg"].(string))
+			}
+			if len(got) != len(tt.wantLogs) {
+				t.Fatalf("logged %q, want %q", got, tt.wantLogs)
+			}
+			for i, want := range tt.wantLogs {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("log %d = %q, want it to contain %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestDrainServer(t *testing.T) {
+	tests := []struct {
+		name string
+		// hold is how long the in-flight request takes once shutdown has
+		// begun.
+		hold        time.Duration
+		timeout     time.Duration
+		wantServed  bool
+		wantLog     string
+		maxDuration time.Duration
+	}{
+		{
+			name:        "in-flight request completes",
+			hold:        200 * time.Millisecond,
+			timeout:     5 * time.Second,
+			wantServed:  true,
+			wantLog:     "HTTP server drained in",
+			maxDuration: 4 * time.Second,
+		},
+		{
+			name:        "gives up at the deadline",
+			hold:        10 * time.Second,
+			timeout:     200 * time.Millisecond,
+			wantLog:     "HTTP server did not drain within 200ms",
+			maxDuration: 2 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			metrics := newHTTPMetrics(parseTestConfig(t))
+			started, release := make(chan struct{}), make(chan struct{})
+			defer close(release)
+			handler := instrumentHandler("/slow", metrics, false, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				select {
+				case <-time.After(tt.hold):
+				case <-release:
+				}
+				_, _ = w.Write([]byte("done"))
+			}))
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := &http.Server{Handler: handler}
+			go func() { _ = srv.Serve(listener) }()
+
+			type result struct {
+				status int
+				err    error
+			}
+			results := make(chan result, 1)
+			go func() {
+				resp, err := http.Get("http://" + listener.Addr().String() + "/slow")
+				if err != nil {
+					results <- result{err: err}
+					return
+				}
+				defer resp.Body.Close()
+				_, err = io.ReadAll(resp.Body)
+				results <- result{status: resp.StatusCode, err: err}
+			}()
+			<-started
+
+			begin := time.Now()
+			drainServer("HTTP", srv, tt.timeout, &metrics.inFlight)
+			if elapsed := time.Since(begin); elapsed > tt.maxDuration {
+				t.Errorf("drainServer took %s, want at most %s", elapsed, tt.maxDuration)
+			}
+
+			res := <-results
+			if served := res.err == nil && res.status == http.StatusOK; served != tt.wantServed {
+				t.Errorf("request served = %v (status %d, err %v), want %v", served, res.status, res.err, tt.wantServed)
+			}
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("logs lack %q:\n%s", tt.wantLog, logs)
+			}
+			if !strings.Contains(logs.String(), "draining 1 in-flight requests") {
+				t.Errorf("logs lack the in-flight count:\n%s", logs)
+			}
+		})
+	}
+}
+
+func TestWaitIdle(t *testing.T) {
+	tests := []struct {
+		name     string
+		inFlight int64
+		finishIn time.Duration
+		timeout  time.Duration
+		wantErr  error
+	}{
+		{name: "already idle", timeout: time.Second},
+		{name: "becomes idle", inFlight: 2, finishIn: 50 * time.Millisecond, timeout: 5 * time.Second},
+		{name: "deadline", inFlight: 1, finishIn: time.Hour, timeout: 50 * time.Millisecond, wantErr: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inFlight atomic.Int64
+			inFlight.Store(tt.inFlight)
+			if tt.inFlight > 0 {
+				timer := time.AfterFunc(tt.finishIn, func() { inFlight.Store(0) })
+				defer timer.Stop()
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			if err := waitIdle(ctx, &inFlight); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("waitIdle = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// tlsVersionsByName maps -tls-min-version values to protocol versions.
+var tlsVersionsByName = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCurvesByName maps -tls-curves values to key exchange groups.
+var tlsCurvesByName = map[string]tls.CurveID{
+	"X25519":         tls.X25519,
+	"X25519MLKEM768": tls.X25519MLKEM768,
+	"P256":           tls.CurveP256,
+	"P384":           tls.CurveP384,
+	"P521":           tls.CurveP521,
+}
+
+// parseCipherSuites resolves a comma-separated list of IANA cipher suite
+// names. Only suites Go considers secure are accepted, and TLS 1.3 suites
+// are rejected because Go does not allow them to be configured.
+func parseCipherSuites(list string) ([]uint16, error) {
+	byName := make(map[string]*tls.CipherSuite)
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite
+	}
+	var ids []uint16
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		suite, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		if !slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+			return nil, fmt.Errorf("cipher suite %q is TLS 1.3 only and cannot be configured", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
+
+// parseCurves resolves a comma-separated list of -tls-curves names.
+func parseCurves(list string) ([]tls.CurveID, error) {
+	var curves []tls.CurveID
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		curve, ok := tlsCurvesByName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q: must be one of %s", name, strings.Join(slices.Sorted(maps.Keys(tlsCurvesByName)), ", "))
+		}
+		curves = append(curves, curve)
+	}
+	return curves, nil
+}
+
+// newTLSConfig builds the HTTP server TLS settings from validated flags,
+// serving whatever certificate certs currently holds.
+func newTLSConfig(cfg *config, certs *certReloader) *tls.Config {
+	return &tls.Config{
+		GetCertificate:   certs.getCertificate,
+		MinVersion:       cfg.tlsMinVersion,
+		CipherSuites:     cfg.tlsCipherSuites,
+		CurvePreferences: cfg.tlsCurves,
+	}
+}
+
+// tlsNames renders configured suites and curves for the startup log.
+func tlsNames[T ~uint16](ids []T, name func(T) string) []string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		names = append(names, name(id))
+	}
+	return names
+}
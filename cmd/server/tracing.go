@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// exportFailureThreshold is the number of consecutive failed exports after
+// which tracing is considered unhealthy.
+const exportFailureThreshold = 3
+
+// defaultOTLPEndpoint is the collector used by the trace and metric
+// exporters when OTEL_EXPORTER_OTLP_ENDPOINT is not set.
+const defaultOTLPEndpoint = "localhost:4317"
+
+// warnIfNoOTLPEndpoint logs a warning when OTEL_EXPORTER_OTLP_ENDPOINT is
+// unset. The gRPC exporter connects lazily, so without a local collector
+// nothing fails at startup; spans are just dropped later.
+func warnIfNoOTLPEndpoint() {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		slog.Warn("OTEL_EXPORTER_OTLP_ENDPOINT is not set; exporting telemetry to the default collector address, spans are dropped if no collector listens there", "endpoint", defaultOTLPEndpoint)
+	}
+}
+
+// initTracer configures the global tracer provider with an OTLP/gRPC
+// exporter. The returned monitor reports whether span export is succeeding.
+func initTracer(ctx context.Context, cfg *config) (*sdktrace.TracerProvider, *exportMonitor, error) {
+
+	res, err := newResource(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientOpts := []otlptracegrpc.Option{}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint == "" {
+		clientOpts = append(clientOpts, otlptracegrpc.WithEndpoint(defaultOTLPEndpoint))
+	}
+	if strings.ToLower(os.Getenv("OTEL_EXPORTER_OTLP_INSECURE")) != "false" {
+		clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+	}
+
+	exporterCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	exporter, err := otlptracegrpc.New(exporterCtx, clientOpts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create otlp exporter: %w", err)
+	}
+
+	monitor := &exportMonitor{SpanExporter: exporter}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(monitor,
+			sdktrace.WithMaxExportBatchSize(cfg.traceBatchSize),
+			sdktrace.WithBatchTimeout(cfg.traceBatchTimeout),
+			sdktrace.WithMaxQueueSize(cfg.traceMaxQueueSize),
+		),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(newSampler(cfg.traceSampleRatio)),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(newPropagator(cfg.propagators))
+
+	return tp, monitor, nil
+}
+
+// newResource describes this service for both the trace and metric
+// pipelines.
+func newResource(ctx context.Context) (*resource.Resource, error) {
+	res, err := resource.New(
+		ctx,
+		resource.WithFromEnv(),
+		resource.WithProcess(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			semconv.ServiceNameKey.String("rest-greeting"),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create resource: %w", err)
+	}
+	return res, nil
+}
+
+// propagatorsByName maps -propagators values to their implementations.
+var propagatorsByName = map[string]func() propagation.TextMapPropagator{
+	"tracecontext": func() propagation.TextMapPropagator { return propagation.TraceContext{} },
+	"baggage":      func() propagation.TextMapPropagator { return propagation.Baggage{} },
+	"b3":           func() propagation.TextMapPropagator { return b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)) },
+}
+
+// newPropagator builds a composite propagator from validated names.
+func newPropagator(names []string) propagation.TextMapPropagator {
+	props := make([]propagation.TextMapPropagator, 0, len(names))
+	for _, name := range names {
+		props = append(props, propagatorsByName[name]())
+	}
+	return propagation.NewCompositeTextMapPropagator(props...)
+}
+
+// exportMonitor decorates a span exporter and tracks consecutive export
+// failures so health checks can tell when the tracing pipeline is broken.
+type exportMonitor struct {
+	sdktrace.SpanExporter
+	consecutiveFailures atomic.Int64
+	// failures and droppedSpans are running totals for the Prometheus
+	// counters; the exporter is created before the registry exists.
+	failures     atomic.Int64
+	droppedSpans atomic.Int64
+	// confirmed is set once any export has succeeded.
+	confirmed atomic.Bool
+}
+
+func (m *exportMonitor) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := m.SpanExporter.ExportSpans(ctx, spans)
+	if err != nil {
+		m.failures.Add(1)
+		m.droppedSpans.Add(int64(len(spans)))
+		if m.consecutiveFailures.Add(1) == exportFailureThreshold {
+			log.Printf("span export failing (%d consecutive failures): %v", exportFailureThreshold, err)
+		}
+		return err
+	}
+	if m.consecutiveFailures.Swap(0) >= exportFailureThreshold {
+		log.Println("span export recovered")
+	}
+	if !m.confirmed.Swap(true) {
+		log.Println("span export confirmed")
+	}
+	return nil
+}
+
+// collectors exposes the export failure totals as Prometheus counters.
+func (m *exportMonitor) collectors(namespace string) []prometheus.Collector {
+	return []prometheus.Collector{
+		prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "trace_export_failures_total",
+				Help:      "Total number of span batches the OTLP exporter failed to deliver.",
+			},
+			func() float64 { return float64(m.failures.Load()) },
+		),
+		prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "trace_export_dropped_spans_total",
+				Help:      "Total number of spans lost in failed OTLP exports.",
+			},
+			func() float64 { return float64(m.droppedSpans.Load()) },
+		),
+	}
+}
+
+// healthy reports whether recent span exports have been succeeding.
+func (m *exportMonitor) healthy() bool {
+	return m.consecutiveFailures.Load() < exportFailureThreshold
+}
+
+// check adapts healthy to the /health/detailed registry.
+func (m *exportMonitor) check(context.Context) error {
+	if !m.healthy() {
+		return fmt.Errorf("%d consecutive span exports failed", m.consecutiveFailures.Load())
+	}
+	return nil
+}
+
+type forceSamplingKey struct{}
+
+// forcedSamplingAttribute marks spans sampled because of a debug signal
+// rather than the ratio, so they can be filtered out of sampled statistics.
+var forcedSamplingAttribute = attribute.Bool("sampling.forced", true)
+
+// newSampler samples ratio of new traces, follows the parent's decision for
+// continued ones, and always samples spans marked by forceSampling.
+func newSampler(ratio float64) sdktrace.Sampler {
+	return forcedSampler{delegate: sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))}
+}
+
+// forcedSampler samples every span started under a context marked by
+// forceSampling and defers to delegate for everything else. The marker is
+// read from the parent context because samplers cannot see the request.
+type forcedSampler struct {
+	delegate sdktrace.Sampler
+}
+
+func (s forcedSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if forced, _ := p.ParentContext.Value(forceSamplingKey{}).(bool); forced {
+		return sdktrace.SamplingResult{
+			Decision:   sdktrace.RecordAndSample,
+			Attributes: []attribute.KeyValue{forcedSamplingAttribute},
+			Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+		}
+	}
+	return s.delegate.ShouldSample(p)
+}
+
+func (s forcedSampler) Description() string {
+	return "ForcedSampler{" + s.delegate.Description() + "}"
+}
+
+// forceSampling marks requests with ?debug=true or X-Debug: 1 so that
+// forcedSampler records their traces regardless of the sample ratio. It
+// must run before the request span is started.
+func forceSampling(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("debug") == "true" || r.Header.Get("X-Debug") == "1" {
+			r = r.WithContext(context.WithValue(r.Context(), forceSamplingKey{}, true))
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// childSpan starts a span under the request span for a step inside a
+// handler. Unsampled requests get ctx back and a no-op end, so the steps
+// cost nothing when the trace is not being recorded.
+func childSpan(ctx context.Context, name string) (context.Context, func()) {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.SpanContext().IsSampled() {
+		return ctx, func() {}
+	}
+	ctx, span := parent.TracerProvider().Tracer("rest-greeting").Start(ctx, name)
+	return ctx, func() { span.End() }
+}
+
+const (
+	// maxTraceHeaderAttributes caps -trace-header-attributes entries.
+	maxTraceHeaderAttributes = 10
+	// maxTraceHeaderValueLen truncates header values copied onto spans.
+	maxTraceHeaderValueLen = 128
+)
+
+// headerAttribute copies a request header onto the request span.
+type headerAttribute struct {
+	header string
+	key    attribute.Key
+}
+
+// parseHeaderAttributes parses "Header:attribute.key,..." mappings.
+func parseHeaderAttributes(spec string) ([]headerAttribute, error) {
+	var mappings []headerAttribute
+	for _, pair := range strings.Split(spec, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		header, key, ok := strings.Cut(pair, ":")
+		header, key = strings.TrimSpace(header), strings.TrimSpace(key)
+		if !ok || header == "" || key == "" {
+			return nil, fmt.Errorf("%q is not Header:attribute.key", pair)
+		}
+		mappings = append(mappings, headerAttribute{header: http.CanonicalHeaderKey(header), key: attribute.Key(key)})
+	}
+	if len(mappings) > maxTraceHeaderAttributes {
+		return nil, fmt.Errorf("at most %d mappings are allowed, got %d", maxTraceHeaderAttributes, len(mappings))
+	}
+	return mappings, nil
+}
+
+// headerAttributes sets span attributes from the mapped request headers
+// that are present. Values are truncated so a client cannot bloat spans.
+func headerAttributes(mappings []headerAttribute, handler http.Handler) http.Handler {
+	if len(mappings) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		if span.IsRecording() {
+			for _, m := range mappings {
+				if v := r.Header.Get(m.header); v != "" {
+					if len(v) > maxTraceHeaderValueLen {
+						v = strings.ToValidUTF8(v[:maxTraceHeaderValueLen], "")
+					}
+					span.SetAttributes(m.key.String(v))
+				}
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// tracingReadyRetryAfter is the Retry-After hint, in seconds, sent while
+// requests are held back waiting for tracing to come up.
+const tracingReadyRetryAfter = "5"
+
+// confirmTracing exports a probe span every interval until the exporter
+// has delivered at least one batch or ctx is done. The probe is force
+// sampled: with a low -trace-sample-ratio it would otherwise rarely, or
+// never, be exported.
+func confirmTracing(ctx context.Context, tp *sdktrace.TracerProvider, monitor *exportMonitor, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	probeCtx := context.WithValue(ctx, forceSamplingKey{}, true)
+	for !monitor.confirmed.Load() {
+		_, span := tp.Tracer("rest-greeting").Start(probeCtx, "tracing.startup_probe")
+		span.End()
+		if err := tp.ForceFlush(ctx); err != nil {
+			log.Printf("tracing not confirmed yet: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// requireTracing answers 503 with Retry-After until the exporter has
+// confirmed that spans are being delivered.
+func requireTracing(monitor *exportMonitor, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !monitor.confirmed.Load() {
+			w.Header().Set("Retry-After", tracingReadyRetryAfter)
+			writeError(w, http.StatusServiceUnavailable, "tracing_not_ready", "span export has not been confirmed yet")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
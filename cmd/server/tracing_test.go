@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newSpanRecorder installs a global tracer provider that records every span
+// for the duration of the test. Handlers must be built after the call, since
+// otelhttp resolves the provider when it wraps a handler.
+func newSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	setTracerProvider(t, sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder
+}
+
+// setTracerProvider installs tp as the global tracer provider for the
+// duration of the test.
+func setTracerProvider(t testing.TB, tp trace.TracerProvider) {
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+}
+
+func TestRequireTracing(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	tests := []struct {
+		name       string
+		confirmed  bool
+		wantStatus int
+	}{
+		{name: "not confirmed", confirmed: false, wantStatus: http.StatusServiceUnavailable},
+		{name: "confirmed", confirmed: true, wantStatus: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monitor := &exportMonitor{}
+			monitor.confirmed.Store(tt.confirmed)
+			rec := httptest.NewRecorder()
+			requireTracing(monitor, next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hello", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.confirmed {
+				return
+			}
+			if got := rec.Header().Get("Retry-After"); got != tracingReadyRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tracingReadyRetryAfter)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			var body errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON body %q: %v", rec.Body, err)
+			}
+			if body.Error.Code != "tracing_not_ready" {
+				t.Errorf("error code = %q, want tracing_not_ready", body.Error.Code)
+			}
+		})
+	}
+}
+
+func TestForcedSampling(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		header      string
+		wantSampled bool
+	}{
+		{name: "plain request", target: "/hello"},
+		{name: "debug query", target: "/hello?debug=true", wantSampled: true},
+		{name: "debug header", target: "/hello", header: "1", wantSampled: true},
+		{name: "other debug values", target: "/hello?debug=1", header: "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A ratio of 0 samples nothing unless forced.
+			recorder := tracetest.NewSpanRecorder()
+			setTracerProvider(t, sdktrace.NewTracerProvider(
+				sdktrace.WithSampler(newSampler(0)),
+				sdktrace.WithSpanProcessor(recorder),
+			))
+			cfg := parseTestConfig(t, "-trace-force-sampling")
+			handler := newHandler(cfg, newServer(cfg, newTestDeps(cfg)))
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Debug", tt.header)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			var server sdktrace.ReadOnlySpan
+			for _, span := range recorder.Ended() {
+				if span.SpanKind() == trace.SpanKindServer {
+					server = span
+				}
+			}
+			if sampled := server != nil && server.SpanContext().IsSampled(); sampled != tt.wantSampled {
+				t.Fatalf("request span sampled = %v, want %v", sampled, tt.wantSampled)
+			}
+			if !tt.wantSampled {
+				return
+			}
+			if !slices.Contains(server.Attributes(), forcedSamplingAttribute) {
+				t.Errorf("forced span attributes %v lack %v", server.Attributes(), forcedSamplingAttribute)
+			}
+		})
+	}
+}
+
+// TestConfirmTracingIgnoresSampleRatio checks that -tracing-required still
+// becomes ready with -trace-sample-ratio=0: the startup probe must be
+// exported even though the ratio samples nothing.
+func TestConfirmTracingIgnoresSampleRatio(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	monitor := &exportMonitor{SpanExporter: exporter}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(newSampler(0)),
+		sdktrace.WithBatcher(monitor),
+	)
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	confirmTracing(ctx, tp, monitor, 10*time.Millisecond)
+
+	if !monitor.confirmed.Load() {
+		t.Fatal("tracing was not confirmed")
+	}
+	spans := exporter.GetSpans()
+	if len(spans) == 0 || spans[0].Name != "tracing.startup_probe" {
+		t.Fatalf("exported spans %v, want the startup probe", spans)
+	}
+}
+
+func TestMissingOTLPEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		wantWarn bool
+	}{
+		{name: "unset", endpoint: "", wantWarn: true},
+		{name: "set", endpoint: "collector:4317"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", tt.endpoint)
+			logs := captureLogs(t)
+
+			warnIfNoOTLPEndpoint()
+
+			records := logRecords(t, logs)
+			if got := len(records) == 1; got != tt.wantWarn {
+				t.Fatalf("logged %v, want a warning = %v", records, tt.wantWarn)
+			}
+			if tt.wantWarn && (records[0]["level"] != "WARN" || records[0]["endpoint"] != defaultOTLPEndpoint) {
+				t.Errorf("warning %v should be WARN and name %s", records[0], defaultOTLPEndpoint)
+			}
+		})
+	}
+}
+
+// TestInitTracerWithoutEndpoint checks that a missing endpoint and no
+// collector does not fail startup.
+func TestInitTracerWithoutEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	setTracerProvider(t, otel.GetTracerProvider())
+	propagator := otel.GetTextMapPropagator()
+	t.Cleanup(func() { otel.SetTextMapPropagator(propagator) })
+
+	tp, monitor, err := initTracer(context.Background(), parseTestConfig(t))
+	if err != nil {
+		t.Fatalf("initTracer: %v", err)
+	}
+	if monitor == nil {
+		t.Fatal("initTracer returned no export monitor")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_ = tp.Shutdown(ctx)
+}
+
+// TestTracestateReachesOutboundCalls sends traceparent and tracestate into
+// /hello and checks what the user service called through the outbound client
+// receives.
+func TestTracestateReachesOutboundCalls(t *testing.T) {
+	const (
+		traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+		traceparent = "00-" + traceID + "-00f067aa0ba902b7-01"
+	)
+	tests := []struct {
+		name          string
+		propagators   string
+		tracestate    string
+		wantForwarded bool
+	}{
+		{name: "single vendor", propagators: "tracecontext,baggage", tracestate: "congo=t61rcWkgMzE", wantForwarded: true},
+		{name: "several vendors", propagators: "tracecontext", tracestate: "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE", wantForwarded: true},
+		{name: "tracecontext disabled", propagators: "b3", tracestate: "congo=t61rcWkgMzE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newSpanRecorder(t)
+			var got http.Header
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+				w.WriteHeader(http.StatusNotFound)
+			}))
+			defer backend.Close()
+
+			cfg := parseTestConfig(t, "-propagators", tt.propagators, "-user-service-url", backend.URL)
+			previous := otel.GetTextMapPropagator()
+			otel.SetTextMapPropagator(newPropagator(cfg.propagators))
+			t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+
+			deps := newTestDeps(cfg)
+			deps.client = newOutboundClient(cfg.outboundOptions())
+			deps.greeter = newTestDBGreeter(&httpUserStore{client: deps.client, baseURL: cfg.userServiceURL})
+			handler := newHandler(cfg, newServer(cfg, deps))
+
+			req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+			req.Header.Set(cfg.userIDHeader, "u1")
+			req.Header.Set("traceparent", traceparent)
+			req.Header.Set("tracestate", tt.tracestate)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			if got == nil {
+				t.Fatal("user service was not called")
+			}
+			if !tt.wantForwarded {
+				if got.Get("traceparent") != "" || got.Get("tracestate") != "" {
+					t.Errorf("outbound traceparent = %q, tracestate = %q, want neither", got.Get("traceparent"), got.Get("tracestate"))
+				}
+				return
+			}
+			parts := strings.Split(got.Get("traceparent"), "-")
+			if len(parts) != 4 || parts[1] != traceID {
+				t.Errorf("outbound traceparent = %q, want trace ID %s", got.Get("traceparent"), traceID)
+			}
+			if got.Get("tracestate") != tt.tracestate {
+				t.Errorf("outbound tracestate = %q, want %q", got.Get("tracestate"), tt.tracestate)
+			}
+		})
+	}
+}
+
+// switchableExporter fails every export while failing is set.
+type switchableExporter struct {
+	failing atomic.Bool
+}
+
+func (e *switchableExporter) ExportSpans(context.Context, []sdktrace.ReadOnlySpan) error {
+	if e.failing.Load() {
+		return errors.New("collector unavailable")
+	}
+	return nil
+}
+
+func (e *switchableExporter) Shutdown(context.Context) error { return nil }
+
+func TestTraceExportFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		// batches are exported in order; true fails the export.
+		batches      []bool
+		wantFailures float64
+		wantDropped  float64
+		wantHealthy  bool
+		wantLogs     []string
+	}{
+		{name: "all delivered", batches: []bool{false, false}, wantHealthy: true, wantLogs: []string{"span export confirmed"}},
+		{name: "one failure", batches: []bool{true}, wantFailures: 1, wantDropped: 2, wantHealthy: true},
+		{
+			name:         "threshold reached",
+			batches:      []bool{true, true, true},
+			wantFailures: 3,
+			wantDropped:  6,
+			wantLogs:     []string{"span export failing (3 consecutive failures): collector unavailable"},
+		},
+		{
+			name:         "recovered",
+			batches:      []bool{true, true, true, true, false},
+			wantFailures: 4,
+			wantDropped:  8,
+			wantHealthy:  true,
+			wantLogs:     []string{"span export failing", "span export recovered"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			exporter := &switchableExporter{}
+			monitor := &exportMonitor{SpanExporter: exporter}
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(monitor.collectors("")...)
+			tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(monitor))
+			defer func() { _ = tp.Shutdown(context.Background()) }()
+
+			for _, fail := range tt.batches {
+				exporter.failing.Store(fail)
+				for range 2 {
+					_, span := tp.Tracer("test").Start(context.Background(), "work")
+					span.End()
+				}
+				// A failed export is reported through ForceFlush too.
+				_ = tp.ForceFlush(context.Background())
+			}
+
+			want := fmt.Sprintf(`
+# HELP trace_export_dropped_spans_total Total number of spans lost in failed OTLP exports.
+# TYPE trace_export_dropped_spans_total counter
+trace_export_dropped_spans_total %v
+# HELP trace_export_failures_total Total number of span batches the OTLP exporter failed to deliver.
+# TYPE trace_export_failures_total counter
+trace_export_failures_total %v
+`, tt.wantDropped, tt.wantFailures)
+			if err := testutil.GatherAndCompare(registry, strings.NewReader(want)); err != nil {
+				t.Error(err)
+			}
+			if got := monitor.healthy(); got != tt.wantHealthy {
+				t.Errorf("healthy = %v, want %v", got, tt.wantHealthy)
+			}
+			for _, line := range tt.wantLogs {
+				if !strings.Contains(logs.String(), line) {
+					t.Errorf("logs lack %q:\n%s", line, logs)
+				}
+			}
+		})
+	}
+}
+
+func TestTraceHeaderAttributes(t *testing.T) {
+	recorder := newSpanRecorder(t)
+	cfg := parseTestConfig(t, "-trace-header-attributes", "x-tenant-id:tenant.id, X-User-Id:user.id, X-Region:cloud.region")
+	app := newServer(cfg, newTestDeps(cfg))
+
+	long := strings.Repeat("u", maxTraceHeaderValueLen+50)
+	req := httptest.NewRequest(http.MethodGet, "/hello?name=Ada", nil)
+	req.Header.Set("X-Tenant-Id", "acme")
+	req.Header.Set("X-User-Id", long)
+	app.ServeHTTP(httptest.NewRecorder(), req)
+
+	var attrs map[string]string
+	for _, s := range recorder.Ended() {
+		if s.SpanKind() != trace.SpanKindServer {
+			continue
+		}
+		attrs = make(map[string]string)
+		for _, kv := range s.Attributes() {
+			attrs[string(kv.Key)] = kv.Value.Emit()
+		}
+	}
+	if attrs == nil {
+		t.Fatal("no server span recorded for /hello")
+	}
+	want := map[string]string{
+		"tenant.id": "acme",
+		"user.id":   long[:maxTraceHeaderValueLen],
+	}
+	for key, value := range want {
+		if got, ok := attrs[key]; !ok || got != value {
+			t.Errorf("attribute %s = %q (present %v), want %q", key, got, ok, value)
+		}
+	}
+	if got, ok := attrs["cloud.region"]; ok {
+		t.Errorf("attribute cloud.region = %q, want it unset for a missing header", got)
+	}
+}
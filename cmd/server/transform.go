@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// nameTransformsByName maps -name-transforms values to their
+// implementations. They are applied in the order given on the command line.
+var nameTransformsByName = map[string]func(string) string{
+	"trim": strings.TrimSpace,
+	// titlecase upper-cases the first letter of each word and lower-cases
+	// the rest, so "mcDONALD" becomes "Mcdonald".
+	"titlecase": func(s string) string { return cases.Title(language.Und).String(s) },
+	// nfc composes characters, so "e" plus a combining acute accent and a
+	// precomposed "é" greet identically.
+	"nfc":        norm.NFC.String,
+	"stripemoji": stripEmoji,
+}
+
+// emojiRanges covers the emoji blocks plus the characters that combine
+// emoji into sequences. It deliberately leaves out other symbols such as
+// ©, ®, °, ™ and arrows, which belong in names like "Café™" as text.
+var emojiRanges = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x200d, Hi: 0x200d, Stride: 1}, // zero width joiner
+		{Lo: 0x20e3, Hi: 0x20e3, Stride: 1}, // combining enclosing keycap
+		{Lo: 0x231a, Hi: 0x231b, Stride: 1}, // watch, hourglass
+		{Lo: 0x23e9, Hi: 0x23fa, Stride: 1}, // media controls, alarm clock
+		{Lo: 0x2600, Hi: 0x27bf, Stride: 1}, // miscellaneous symbols, dingbats
+		{Lo: 0x2b1b, Hi: 0x2b1c, Stride: 1}, // large squares
+		{Lo: 0x2b50, Hi: 0x2b55, Stride: 5}, // star, circle
+		{Lo: 0xfe0f, Hi: 0xfe0f, Stride: 1}, // emoji presentation selector
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f000, Hi: 0x1faff, Stride: 1}, // pictographs, flags, skin tones
+		{Lo: 0xe0020, Hi: 0xe007f, Stride: 1}, // tags in subdivision flags
+	},
+}
+
+// stripEmoji removes emoji along with the joiners, variation selectors and
+// skin tone modifiers that combine them.
+func stripEmoji(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.Is(emojiRanges, r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// transformName applies transforms to name in order.
+func transformName(name string, transforms []func(string) string) string {
+	for _, transform := range transforms {
+		name = transform(name)
+	}
+	return name
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripEmoji(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "Ada 👋", want: "Ada "},
+		{in: "👩🏽‍💻Grace", want: "Grace"},
+		{in: "Linus 🇫🇮", want: "Linus "},
+		{in: "Ada ☀️", want: "Ada "},
+		{in: "Café™", want: "Café™"},
+		{in: "©®° Ada", want: "©®° Ada"},
+		{in: "A→B ↔ C", want: "A→B ↔ C"},
+		{in: "Zoë", want: "Zoë"},
+	}
+	for _, tt := range tests {
+		if got := stripEmoji(tt.in); got != tt.want {
+			t.Errorf("stripEmoji(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNameTransforms(t *testing.T) {
+	tests := []struct {
+		name       string
+		transforms string
+		in         string
+		want       string
+		wantErr    string
+	}{
+		{name: "none", transforms: "", in: " ada ", want: " ada "},
+		{name: "trim then titlecase", transforms: "trim,titlecase", in: "  ada LOVELACE ", want: "Ada Lovelace"},
+		{name: "titlecase then stripemoji", transforms: "titlecase,stripemoji", in: "ADA👋", want: "Ada"},
+		{name: "stripemoji then titlecase", transforms: "stripemoji,titlecase", in: "👋ada", want: "Ada"},
+		{name: "stripemoji then trim", transforms: "stripemoji,trim", in: "Ada 👋", want: "Ada"},
+		{name: "trim then stripemoji keeps the gap", transforms: "trim,stripemoji", in: "Ada 👋", want: "Ada "},
+		{name: "nfc then titlecase", transforms: "nfc,titlecase", in: "zoë", want: "Zoë"},
+		{name: "everything", transforms: "stripemoji,trim,nfc,titlecase", in: " 👋 zoë SMITH ", want: "Zoë Smith"},
+		{name: "unknown transform", transforms: "trim,uppercase", wantErr: `invalid -name-transforms entry "uppercase"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseConfig(newTestFlagSet(), []string{"-name-transforms", tt.transforms})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseConfig error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseConfig: %v", err)
+			}
+			if got := transformName(tt.in, cfg.nameTransforms); got != tt.want {
+				t.Errorf("transformName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// uiFiles holds the demo page and favicon served with -serve-ui.
+//
+//go:embed ui/index.html ui/favicon.ico
+var uiFiles embed.FS
+
+// uiIndexTemplate is the demo page. It is rendered with the path the form
+// should call, since -api-prefix and -unversioned-alias move /hello.
+var uiIndexTemplate = template.Must(template.ParseFS(uiFiles, "ui/index.html"))
+
+// uiIndex renders the demo page once for helloPath and serves the result.
+func uiIndex(helloPath string) http.Handler {
+	var buf bytes.Buffer
+	if err := uiIndexTemplate.Execute(&buf, struct{ HelloPath string }{helloPath}); err != nil {
+		panic("rendering ui/index.html: " + err.Error())
+	}
+	page := buf.Bytes()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		http.ServeContent(w, r, "index.html", time.Time{}, bytes.NewReader(page))
+	})
+}
+
+// uiFile serves one embedded file. Content-Type is derived from the file
+// extension by http.ServeFileFS.
+func uiFile(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		http.ServeFileFS(w, r, uiFiles, name)
+	})
+}
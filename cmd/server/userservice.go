@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// maxUserProfileBytes bounds how much of a user service response is read.
+const maxUserProfileBytes = 64 << 10
+
+// httpUserStore reads profiles from a user service over HTTP. GET
+// <baseURL>/users/<id> answers 200 with
+//
+//	{"nickname": "Ace", "preferred_language": "en"}
+//
+// or 404 for users without a profile.
+type httpUserStore struct {
+	// client is the shared outbound client, so lookups are traced and
+	// pooled like every other downstream call.
+	client  *http.Client
+	baseURL string
+}
+
+func (s *httpUserStore) lookupUser(ctx context.Context, userID string) (userProfile, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/users/"+url.PathEscape(userID), nil)
+	if err != nil {
+		return userProfile{}, fmt.Errorf("build user service request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return userProfile{}, err
+	}
+	defer func() {
+		// Drain what is left so the connection goes back to the pool.
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxUserProfileBytes))
+		_ = resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return userProfile{}, errUserNotFound
+	default:
+		return userProfile{}, fmt.Errorf("user service answered %s", resp.Status)
+	}
+
+	var body struct {
+		Nickname string `json:"nickname"`
+		Language string `json:"preferred_language"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxUserProfileBytes)).Decode(&body); err != nil {
+		return userProfile{}, fmt.Errorf("decode user profile: %w", err)
+	}
+	return userProfile{nickname: body.Nickname, language: body.Language}, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestOutboundClient() *http.Client {
+	return newOutboundClient(outboundOptions{
+		timeout:             time.Second,
+		maxIdleConns:        10,
+		maxIdleConnsPerHost: 10,
+		idleConnTimeout:     time.Minute,
+	})
+}
+
+func TestHTTPUserStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    userProfile
+		wantErr error
+	}{
+		{
+			name:   "hit",
+			status: http.StatusOK,
+			body:   `{"nickname":"Ace","preferred_language":"fr"}`,
+			want:   userProfile{nickname: "Ace", language: "fr"},
+		},
+		{name: "miss", status: http.StatusNotFound, wantErr: errUserNotFound},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: errAny},
+		{name: "malformed body", status: http.StatusOK, body: `{"nickname":`, wantErr: errAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := newSpanRecorder(t)
+			var gotPath string
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.EscapedPath()
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer backend.Close()
+
+			store := &httpUserStore{client: newTestOutboundClient(), baseURL: backend.URL}
+			got, err := store.lookupUser(context.Background(), "u 1/2")
+
+			if gotPath != "/users/u%201%2F2" {
+				t.Errorf("path = %q, want the user ID escaped as one segment", gotPath)
+			}
+			switch {
+			case tt.wantErr == errAny && err == nil:
+				t.Fatal("lookupUser succeeded, want an error")
+			case tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Fatalf("lookupUser error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("profile = %+v, want %+v", got, tt.want)
+			}
+
+			// Lookups go through the traced outbound client.
+			var clientSpans int
+			for _, s := range recorder.Ended() {
+				if s.SpanKind() == trace.SpanKindClient {
+					clientSpans++
+				}
+			}
+			if clientSpans != 1 {
+				t.Errorf("client spans = %d, want 1", clientSpans)
+			}
+		})
+	}
+}
+
+// errAny marks table cases that expect some error without caring which.
+var errAny = errors.New("any error")
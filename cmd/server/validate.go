@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// validateRuntime runs the checks behind -validate that parseConfig cannot
+// do on its own: it binds and releases both listeners, loads the TLS
+// certificate and checks that the log output is writable. Nothing is
+// created or modified. All problems are reported together.
+func validateRuntime(cfg *config) error {
+	var errs []error
+
+	for _, l := range []struct{ name, addr string }{
+		{"HTTP", cfg.httpAddr},
+		{"metrics", cfg.metricsAddr},
+	} {
+		ln, err := newListener(context.Background(), l.addr, listenerOptions{tcpTuning: cfg.tcpTuning})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s listener: %w", l.name, err))
+			continue
+		}
+		_ = ln.Close()
+	}
+
+	if cfg.tlsCertFile != "" {
+		if _, err := newCertReloader(cfg.tlsCertFile, cfg.tlsKeyFile); err != nil {
+			errs = append(errs, fmt.Errorf("TLS certificate: %w", err))
+		}
+	}
+
+	switch cfg.logOutput {
+	case "stderr", "stdout":
+	default:
+		if err := checkLogOutput(cfg.logOutput); err != nil {
+			errs = append(errs, fmt.Errorf("log output: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkLogOutput reports whether the server could append to path without
+// touching the file system: an existing file is opened for writing and
+// closed again, and a missing one only needs a writable parent directory.
+func checkLogOutput(path string) error {
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", path)
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	info, err = os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if err := dirWritable(dir); err != nil {
+		return fmt.Errorf("cannot create files in %s: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// dirWritable is not checked outside Unix; opening the log file at startup
+// still reports a permission problem.
+func dirWritable(dir string) error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateRuntime(t *testing.T) {
+	dir := t.TempDir()
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "log output in a missing directory",
+			args: []string{"-log-output", filepath.Join(dir, "missing", "server.log")},
+			want: []string{"log output:", "no such file or directory"},
+		},
+		{
+			name: "log output is a directory",
+			args: []string{"-log-output", dir},
+			want: []string{"log output:", "not a regular file"},
+		},
+		{
+			name: "missing TLS certificate",
+			args: []string{"-tls-cert-file", filepath.Join(dir, "cert.pem"), "-tls-key-file", filepath.Join(dir, "key.pem")},
+			want: []string{"TLS certificate:"},
+		},
+		{
+			name: "address in use",
+			args: []string{"-http-addr", busy.Addr().String()},
+			want: []string{"HTTP listener:"},
+		},
+		{
+			name: "every problem reported",
+			args: []string{"-http-addr", busy.Addr().String(), "-log-output", filepath.Join(dir, "missing", "server.log")},
+			want: []string{"HTTP listener:", "log output:"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Later flags override these free ports.
+			args := append([]string{"-http-addr", "127.0.0.1:0", "-metrics-addr", "127.0.0.1:0"}, tt.args...)
+			err := validateRuntime(parseTestConfig(t, args...))
+			if err == nil {
+				t.Fatal("validateRuntime succeeded, want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error = %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateRuntimeCreatesNothing(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "server.log")
+	cfg := parseTestConfig(t, "-http-addr", "127.0.0.1:0", "-metrics-addr", "127.0.0.1:0", "-log-output", logPath)
+
+	if err := validateRuntime(cfg); err != nil {
+		t.Fatalf("validateRuntime: %v", err)
+	}
+	if _, err := os.Stat(logPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("stat %s: %v, want the log file not to be created", logPath, err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// dirWritable reports whether the process may create entries in dir.
+func dirWritable(dir string) error {
+	const wOK, xOK = 0x2, 0x1
+	return syscall.Access(dir, wOK|xOK)
+}
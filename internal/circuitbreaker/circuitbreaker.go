@@ -0,0 +1,205 @@
+// Package circuitbreaker stops calling a failing dependency for a while so
+// it can recover, instead of piling more load onto it.
+//
+// A Breaker starts Closed and passes calls through. After FailureThreshold
+// consecutive failures it opens and rejects calls with ErrOpen. Once
+// ResetTimeout has elapsed it becomes HalfOpen and lets a single trial call
+// through: success closes the breaker again, failure re-opens it.
+//
+// Outcomes count toward the state a call was admitted in. A slow call that
+// started while Closed and finishes after the breaker moved on is ignored,
+// so it can neither decide a half-open trial nor re-open a fresh breaker.
+package circuitbreaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrOpen is returned by Do while the breaker rejects calls.
+var ErrOpen = errors.New("circuitbreaker: breaker is open")
+
+// errPanicked is recorded for calls whose function panicked.
+var errPanicked = errors.New("circuitbreaker: call panicked")
+
+// State is the state of a Breaker. Its numeric value is exported by the
+// state gauge.
+type State int
+
+const (
+	Closed State = iota
+	HalfOpen
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// Options configures a Breaker. Zero values select the defaults.
+type Options struct {
+	// FailureThreshold is the number of consecutive failures that opens
+	// the breaker. Defaults to 5.
+	FailureThreshold int
+	// ResetTimeout is how long the breaker stays open before allowing a
+	// trial call. Defaults to 30s.
+	ResetTimeout time.Duration
+	// OnStateChange, if set, is called after every state transition. It
+	// runs with the breaker locked and must not call back into it.
+	OnStateChange func(from, to State)
+}
+
+// Breaker is a circuit breaker. It is safe for concurrent use.
+type Breaker struct {
+	name string
+	opts Options
+	now  func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	trial    bool // a half-open trial call is in flight
+	// generation advances on every transition; calls record their outcome
+	// only if it has not moved since they were admitted.
+	generation uint64
+}
+
+// New returns a closed Breaker. The name identifies it in metrics.
+func New(name string, opts Options) *Breaker {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 5
+	}
+	if opts.ResetTimeout <= 0 {
+		opts.ResetTimeout = 30 * time.Second
+	}
+	return &Breaker{name: name, opts: opts, now: time.Now}
+}
+
+// Do calls fn if the breaker allows it and records the outcome. It returns
+// ErrOpen without calling fn while the breaker is open, or while a
+// half-open trial call is already in flight. A panic in fn is recorded as
+// a failure and then re-raised.
+func (b *Breaker) Do(fn func() error) (err error) {
+	generation, err := b.allow()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			// Without this a panicking trial call would leave the breaker
+			// half-open with the trial in flight forever.
+			b.record(generation, errPanicked)
+			panic(v)
+		}
+		b.record(generation, err)
+	}()
+	return fn()
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+	return b.state
+}
+
+// StateGauge returns a collector exporting the breaker state as
+// circuit_breaker_state{breaker="<name>"}: 0 closed, 1 half-open, 2 open.
+func (b *Breaker) StateGauge(namespace string) prometheus.Collector {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "circuit_breaker_state",
+			Help:        "Circuit breaker state: 0 closed, 1 half-open, 2 open.",
+			ConstLabels: prometheus.Labels{"breaker": b.name},
+		},
+		func() float64 { return float64(b.State()) },
+	)
+}
+
+// allow admits a call and returns the generation it was admitted in.
+func (b *Breaker) allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+
+	switch b.state {
+	case Open:
+		return 0, ErrOpen
+	case HalfOpen:
+		if b.trial {
+			return 0, ErrOpen
+		}
+		b.trial = true
+	}
+	return b.generation, nil
+}
+
+// record applies the outcome of a call admitted in generation. Outcomes
+// from an earlier generation are stale and ignored.
+func (b *Breaker) record(generation uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+	if b.state == HalfOpen {
+		b.trial = false
+		if err != nil {
+			b.transition(Open)
+		} else {
+			b.transition(Closed)
+		}
+		return
+	}
+
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == Closed && b.failures >= b.opts.FailureThreshold {
+		b.transition(Open)
+	}
+}
+
+// refresh moves an open breaker to half-open once the reset timeout has
+// elapsed. The caller must hold b.mu.
+func (b *Breaker) refresh() {
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.opts.ResetTimeout {
+		b.transition(HalfOpen)
+	}
+}
+
+// transition changes state and notifies OnStateChange. The caller must
+// hold b.mu.
+func (b *Breaker) transition(to State) {
+	from := b.state
+	if from == to {
+		return
+	}
+	b.state = to
+	b.failures = 0
+	b.generation++
+	if to == Open {
+		b.openedAt = b.now()
+	}
+	if b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(from, to)
+	}
+}
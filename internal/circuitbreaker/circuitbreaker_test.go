@@ -0,0 +1,268 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+var errBackend = errors.New("backend down")
+
+// fakeClock is a manually advanced time source for Breaker.now.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestBreaker() (*Breaker, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	b := New("test", Options{FailureThreshold: 2, ResetTimeout: time.Minute})
+	b.now = clock.now
+	return b, clock
+}
+
+func fail() error    { return errBackend }
+func succeed() error { return nil }
+
+func TestBreakerTransitions(t *testing.T) {
+	type step struct {
+		advance   time.Duration
+		fn        func() error
+		wantErr   error
+		wantState State
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "stays closed below the threshold",
+			steps: []step{
+				{fn: fail, wantErr: errBackend, wantState: Closed},
+				{fn: succeed, wantState: Closed},
+				{fn: fail, wantErr: errBackend, wantState: Closed},
+			},
+		},
+		{
+			name: "opens at the threshold and rejects calls",
+			steps: []step{
+				{fn: fail, wantErr: errBackend, wantState: Closed},
+				{fn: fail, wantErr: errBackend, wantState: Open},
+				{fn: succeed, wantErr: ErrOpen, wantState: Open},
+				{advance: 59 * time.Second, fn: succeed, wantErr: ErrOpen, wantState: Open},
+			},
+		},
+		{
+			name: "successful trial closes",
+			steps: []step{
+				{fn: fail, wantErr: errBackend},
+				{fn: fail, wantErr: errBackend, wantState: Open},
+				{advance: time.Minute, fn: succeed, wantState: Closed},
+				{fn: succeed, wantState: Closed},
+			},
+		},
+		{
+			name: "failed trial re-opens",
+			steps: []step{
+				{fn: fail, wantErr: errBackend},
+				{fn: fail, wantErr: errBackend, wantState: Open},
+				{advance: time.Minute, fn: fail, wantErr: errBackend, wantState: Open},
+				{fn: succeed, wantErr: ErrOpen, wantState: Open},
+				{advance: time.Minute, fn: succeed, wantState: Closed},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, clock := newTestBreaker()
+			for i, s := range tt.steps {
+				clock.advance(s.advance)
+				if err := b.Do(s.fn); err != s.wantErr {
+					t.Fatalf("step %d: Do error = %v, want %v", i, err, s.wantErr)
+				}
+				if got := b.State(); got != s.wantState {
+					t.Fatalf("step %d: state = %v, want %v", i, got, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerHalfOpenAfterResetTimeout(t *testing.T) {
+	b, clock := newTestBreaker()
+	_ = b.Do(fail)
+	_ = b.Do(fail)
+	clock.advance(time.Minute)
+	if got := b.State(); got != HalfOpen {
+		t.Fatalf("state = %v, want %v", got, HalfOpen)
+	}
+}
+
+func TestBreakerTrialInFlight(t *testing.T) {
+	b, clock := newTestBreaker()
+	_ = b.Do(fail)
+	_ = b.Do(fail)
+	clock.advance(time.Minute)
+
+	var concurrent error
+	err := b.Do(func() error {
+		// The trial is in flight: any other call is rejected.
+		concurrent = b.Do(succeed)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("trial Do error = %v", err)
+	}
+	if concurrent != ErrOpen {
+		t.Fatalf("call during trial error = %v, want %v", concurrent, ErrOpen)
+	}
+	if got := b.State(); got != Closed {
+		t.Fatalf("state after trial = %v, want %v", got, Closed)
+	}
+}
+
+func TestBreakerPanic(t *testing.T) {
+	tests := []struct {
+		name      string
+		halfOpen  bool
+		wantState State
+	}{
+		{name: "closed counts a failure", wantState: Closed},
+		{name: "half-open trial re-opens", halfOpen: true, wantState: Open},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, clock := newTestBreaker()
+			if tt.halfOpen {
+				_ = b.Do(fail)
+				_ = b.Do(fail)
+				clock.advance(time.Minute)
+			}
+
+			func() {
+				defer func() {
+					if v := recover(); v != "boom" {
+						t.Fatalf("recovered %v, want the original panic", v)
+					}
+				}()
+				_ = b.Do(func() error { panic("boom") })
+			}()
+
+			if got := b.State(); got != tt.wantState {
+				t.Fatalf("state = %v, want %v", got, tt.wantState)
+			}
+			if tt.halfOpen {
+				// The trial slot was released: the next trial gets through.
+				clock.advance(time.Minute)
+				if err := b.Do(succeed); err != nil {
+					t.Fatalf("next trial Do error = %v", err)
+				}
+				return
+			}
+			// The panic was the first of two failures.
+			_ = b.Do(fail)
+			if got := b.State(); got != Open {
+				t.Fatalf("state after second failure = %v, want %v", got, Open)
+			}
+		})
+	}
+}
+
+func TestBreakerOnStateChange(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	var got []State
+	b := New("test", Options{
+		FailureThreshold: 1,
+		ResetTimeout:     time.Minute,
+		OnStateChange:    func(_, to State) { got = append(got, to) },
+	})
+	b.now = clock.now
+
+	_ = b.Do(fail)
+	clock.advance(time.Minute)
+	_ = b.Do(succeed)
+
+	want := []State{Open, HalfOpen, Closed}
+	if !slices.Equal(got, want) {
+		t.Fatalf("transitions = %v, want %v", got, want)
+	}
+}
+
+func TestBreakerIgnoresStaleResults(t *testing.T) {
+	tests := []struct {
+		name string
+		// slowErr is the outcome of a call admitted while Closed that only
+		// finishes once the breaker is half-open.
+		slowErr error
+		// trialErr is the outcome of the half-open trial, which finishes
+		// after the slow call.
+		trialErr  error
+		wantState State
+	}{
+		{name: "stale success does not close", slowErr: nil, trialErr: errBackend, wantState: Open},
+		{name: "stale failure does not re-open", slowErr: errBackend, trialErr: nil, wantState: Closed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, clock := newTestBreaker()
+
+			slowStarted, slowRelease, slowDone := make(chan struct{}), make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(slowDone)
+				_ = b.Do(func() error {
+					close(slowStarted)
+					<-slowRelease
+					return tt.slowErr
+				})
+			}()
+			<-slowStarted
+
+			// Meanwhile the breaker opens and its reset timeout elapses.
+			_ = b.Do(fail)
+			_ = b.Do(fail)
+			clock.advance(time.Minute)
+
+			err := b.Do(func() error {
+				// The slow call finishes while the trial is in flight.
+				close(slowRelease)
+				<-slowDone
+				if got := b.State(); got != HalfOpen {
+					t.Errorf("state after stale result = %v, want %v", got, HalfOpen)
+				}
+				// The trial slot is still taken.
+				if err := b.Do(succeed); err != ErrOpen {
+					t.Errorf("call during trial error = %v, want %v", err, ErrOpen)
+				}
+				return tt.trialErr
+			})
+			if err != tt.trialErr {
+				t.Fatalf("trial Do error = %v, want %v", err, tt.trialErr)
+			}
+			if got := b.State(); got != tt.wantState {
+				t.Fatalf("state = %v, want %v", got, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestBreakerStaleFailureAfterReset(t *testing.T) {
+	b, clock := newTestBreaker()
+
+	// A call admitted before the breaker opened fails after it has closed
+	// again; it must not count toward the fresh breaker's threshold.
+	generation, err := b.allow()
+	if err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	_ = b.Do(fail)
+	_ = b.Do(fail)
+	clock.advance(time.Minute)
+	_ = b.Do(succeed)
+	b.record(generation, errBackend)
+
+	_ = b.Do(fail)
+	if got := b.State(); got != Closed {
+		t.Fatalf("state = %v, want %v: a stale failure was counted", got, Closed)
+	}
+}